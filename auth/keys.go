@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeUpload       Scope = "upload"
+	ScopeDelete       Scope = "delete"
+	ScopePriceWrite   Scope = "price:write"
+	ScopeCacheAdmin   Scope = "cache:admin"
+	ScopeWebhookAdmin Scope = "webhook:admin"
+	ScopeLootAdmin    Scope = "loot:admin"
+	ScopeLootRoll     Scope = "loot:roll"
+	// ScopeAll is stored as is and grants every scope, including the ones
+	// added after the key was created.
+	ScopeAll Scope = "*"
+)
+
+var AllScopes = []Scope{ScopeUpload, ScopeDelete, ScopePriceWrite, ScopeCacheAdmin, ScopeWebhookAdmin, ScopeLootAdmin, ScopeLootRoll}
+
+const tokenPrefix = "lcdn"
+
+var (
+	ErrInvalidKey = errors.New("auth: invalid api key")
+	ErrExpiredKey = errors.New("auth: api key expired")
+	ErrRevokedKey = errors.New("auth: api key revoked")
+)
+
+type Key struct {
+	ID         int64      `json:"id"`
+	KeyID      string     `json:"keyId"`
+	Name       string     `json:"name"`
+	Scopes     []Scope    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (k *Key) HasScope(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps api keys in the api_keys table. Only a SHA-256 of the secret
+// part is stored, the full token is shown once when the key is created.
+type Store struct {
+	db *sql.DB
+	// touched is when last_used_at was last written per key id, see touch.
+	touchLock sync.Mutex
+	touched   map[int64]time.Time
+}
+
+// lastUsedResolution is how often last_used_at is written for a key that
+// is in constant use.
+const lastUsedResolution = time.Minute
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, touched: make(map[int64]time.Time)}
+}
+
+// touch records that key was just used, at most once per
+// lastUsedResolution and without holding up the request.
+func (s *Store) touch(ctx context.Context, key *Key) {
+	now := time.Now()
+	s.touchLock.Lock()
+	if now.Sub(s.touched[key.ID]) < lastUsedResolution {
+		s.touchLock.Unlock()
+		return
+	}
+	s.touched[key.ID] = now
+	s.touchLock.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", key.ID); err != nil {
+			fmt.Printf("Error recording the use of api key %s: %s\n", key.KeyID, err)
+		}
+	}()
+}
+
+func ParseScopes(s string) ([]Scope, error) {
+	scopes := []Scope{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if Scope(part) == ScopeAll {
+			return []Scope{ScopeAll}, nil
+		}
+		known := false
+		for _, scope := range AllScopes {
+			if Scope(part) == scope {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("auth: unknown scope %q", part)
+		}
+		scopes = append(scopes, Scope(part))
+	}
+	return scopes, nil
+}
+
+func joinScopes(scopes []Scope) string {
+	parts := make([]string, len(scopes))
+	for i, s := range scopes {
+		parts[i] = string(s)
+	}
+	return strings.Join(parts, ",")
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create stores a new key and returns the token to hand out, formatted as
+// lcdn_<keyId>_<secret>.
+func (s *Store) Create(ctx context.Context, name string, scopes []Scope, expiresAt *time.Time) (string, *Key, error) {
+	keyID, err := randomHex(6)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO api_keys (key_id, name, secret_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?)",
+		keyID, name, hashSecret(secret), joinScopes(scopes), expiresAt,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+	id, _ := res.LastInsertId()
+	key := &Key{
+		ID:        id,
+		KeyID:     keyID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	return fmt.Sprintf("%s_%s_%s", tokenPrefix, keyID, secret), key, nil
+}
+
+func (s *Store) scanKey(row interface{ Scan(...any) error }, secretHash *string) (*Key, error) {
+	var key Key
+	var scopes string
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.KeyID, &key.Name, secretHash, &scopes, &expiresAt, &revokedAt, &lastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range strings.Split(scopes, ",") {
+		if part != "" {
+			key.Scopes = append(key.Scopes, Scope(part))
+		}
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return &key, nil
+}
+
+const keyColumns = "id, key_id, name, secret_hash, scopes, expires_at, revoked_at, last_used_at, created_at"
+
+// Authenticate resolves a token to its key. The secret is compared in
+// constant time against the stored hash.
+func (s *Store) Authenticate(ctx context.Context, token string) (*Key, error) {
+	parts := strings.SplitN(token, "_", 3)
+	if len(parts) != 3 || parts[0] != tokenPrefix {
+		return nil, ErrInvalidKey
+	}
+	var secretHash string
+	key, err := s.scanKey(s.db.QueryRowContext(ctx, "SELECT "+keyColumns+" FROM api_keys WHERE key_id = ?", parts[1]), &secretHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidKey
+		}
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(parts[2])), []byte(secretHash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevokedKey
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, ErrExpiredKey
+	}
+	s.touch(ctx, key)
+	return key, nil
+}
+
+func (s *Store) List(ctx context.Context) ([]*Key, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+keyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	response := []*Key{}
+	for rows.Next() {
+		var secretHash string
+		key, err := s.scanKey(rows, &secretHash)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, key)
+	}
+	return response, rows.Err()
+}
+
+// Revoke marks a key, addressed by its public key id, as revoked.
+func (s *Store) Revoke(ctx context.Context, keyID string) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE key_id = ? AND revoked_at IS NULL", keyID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("auth: no active key %q", keyID)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+type contextKey struct{}
+
+// Middleware is the single authentication layer of the router. Routes opt
+// in through Protect, everything else passes through untouched.
+type Middleware struct {
+	store        *Store
+	legacySecret string
+	lock         sync.RWMutex
+	routes       map[*mux.Route][]Scope
+}
+
+// NewMiddleware builds the auth middleware. When legacySecret is not empty
+// the old shared Secret value keeps working with every scope.
+func NewMiddleware(store *Store, legacySecret string) *Middleware {
+	return &Middleware{
+		store:        store,
+		legacySecret: legacySecret,
+		routes:       make(map[*mux.Route][]Scope),
+	}
+}
+
+// Protect requires a key holding all of scopes on route.
+func (m *Middleware) Protect(route *mux.Route, scopes ...Scope) *mux.Route {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.routes[route] = scopes
+	return route
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		m.lock.RLock()
+		scopes, protected := m.routes[route]
+		m.lock.RUnlock()
+		if route == nil || !protected {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := m.Authorize(w, r, scopes...)
+		if !ok {
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key)))
+	})
+}
+
+// Authorize checks that the request carries a key holding all of scopes,
+// for handlers that only need a key for some requests. It writes the error
+// response and returns false when it does not.
+func (m *Middleware) Authorize(w http.ResponseWriter, r *http.Request, scopes ...Scope) (*Key, bool) {
+	key, err := m.authenticate(r)
+	if err != nil {
+		if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrExpiredKey) || errors.Is(err, ErrRevokedKey) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return nil, false
+		}
+		fmt.Println("Error authenticating request:", err)
+		http.Error(w, "Error authenticating request", http.StatusInternalServerError)
+		return nil, false
+	}
+	for _, scope := range scopes {
+		if !key.HasScope(scope) {
+			http.Error(w, fmt.Sprintf("Forbidden: missing scope %s", scope), http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return key, true
+}
+
+func (m *Middleware) authenticate(r *http.Request) (*Key, error) {
+	token := r.Header.Get("Secret")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+	if token == "" {
+		return nil, ErrInvalidKey
+	}
+	if m.legacySecret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.legacySecret)) == 1 {
+		return &Key{Name: "legacy", Scopes: []Scope{ScopeAll}}, nil
+	}
+	return m.store.Authenticate(r.Context(), token)
+}
+
+// FromContext returns the key that authenticated the request, if any.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
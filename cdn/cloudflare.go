@@ -0,0 +1,86 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultCloudflareApiUrl = "https://api.cloudflare.com/client/v4"
+	// cloudflareBatchSize is the most files a single purge call accepts.
+	cloudflareBatchSize = 30
+)
+
+// Cloudflare purges urls through the zone purge_cache API.
+type Cloudflare struct {
+	client *http.Client
+	apiUrl string
+	zoneId string
+	token  string
+}
+
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// NewCloudflare returns a purger for zoneId, apiUrl defaults to the public
+// API and only needs changing to point at a stub.
+func NewCloudflare(client *http.Client, apiUrl, zoneId, token string) *Cloudflare {
+	if apiUrl == "" {
+		apiUrl = DefaultCloudflareApiUrl
+	}
+	return &Cloudflare{client: client, apiUrl: strings.TrimSuffix(apiUrl, "/"), zoneId: zoneId, token: token}
+}
+
+func (p *Cloudflare) Purge(ctx context.Context, urls []string) error {
+	for start := 0; start < len(urls); start += cloudflareBatchSize {
+		end := min(start+cloudflareBatchSize, len(urls))
+		if err := p.purgeBatch(ctx, urls[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Cloudflare) purgeBatch(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(map[string][]string{"files": urls})
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/zones/%s/purge_cache", p.apiUrl, p.zoneId)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "cloudflare"); err != nil {
+		return err
+	}
+	var result cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return fmt.Errorf("cdn: decoding the cloudflare response: %w", err)
+	}
+	if !result.Success {
+		messages := []string{}
+		for _, e := range result.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cdn: cloudflare purge failed: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
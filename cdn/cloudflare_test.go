@@ -0,0 +1,136 @@
+package cdn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cloudflareStub records the files of every purge call and answers with
+// respond, which gets the 1-based number of the call.
+type cloudflareStub struct {
+	t       *testing.T
+	respond func(w http.ResponseWriter, call int)
+
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (s *cloudflareStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/client/v4/zones/zone-id/purge_cache" {
+		s.t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+	}
+	if got := r.Header.Get("Authorization"); got != "Bearer api-token" {
+		s.t.Errorf("Authorization is %q", got)
+	}
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		s.t.Errorf("Content-Type is %q", got)
+	}
+	var body struct {
+		Files []string `json:"files"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.t.Errorf("decoding the purge body: %s", err)
+	}
+	s.mu.Lock()
+	s.batches = append(s.batches, body.Files)
+	call := len(s.batches)
+	s.mu.Unlock()
+	s.respond(w, call)
+}
+
+func newCloudflareStub(t *testing.T, respond func(w http.ResponseWriter, call int)) (*cloudflareStub, *Cloudflare) {
+	stub := &cloudflareStub{t: t, respond: respond}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, NewCloudflare(server.Client(), server.URL+"/client/v4/", "zone-id", "api-token")
+}
+
+func testUrls(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://cdn.example.com/file-%d.webp", i)
+	}
+	return urls
+}
+
+func TestCloudflareBatches(t *testing.T) {
+	for _, n := range []int{1, 30, 31, 65} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			stub, purger := newCloudflareStub(t, func(w http.ResponseWriter, call int) {
+				w.Write([]byte(`{"success": true, "errors": []}`))
+			})
+			urls := testUrls(n)
+			if err := purger.Purge(context.Background(), urls); err != nil {
+				t.Fatal(err)
+			}
+			sent := []string{}
+			for i, batch := range stub.batches {
+				if len(batch) == 0 || len(batch) > cloudflareBatchSize {
+					t.Errorf("batch %d has %d files", i, len(batch))
+				}
+				sent = append(sent, batch...)
+			}
+			if want := (n + cloudflareBatchSize - 1) / cloudflareBatchSize; len(stub.batches) != want {
+				t.Errorf("%d urls took %d calls, want %d", n, len(stub.batches), want)
+			}
+			if strings.Join(sent, ",") != strings.Join(urls, ",") {
+				t.Errorf("purged %q, want %q", sent, urls)
+			}
+		})
+	}
+}
+
+func TestCloudflareNoUrls(t *testing.T) {
+	stub, purger := newCloudflareStub(t, func(w http.ResponseWriter, call int) {
+		w.Write([]byte(`{"success": true}`))
+	})
+	if err := purger.Purge(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.batches) != 0 {
+		t.Fatalf("made %d calls without urls", len(stub.batches))
+	}
+}
+
+func TestCloudflareStopsAtTheFailedBatch(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		want    string
+		respond func(w http.ResponseWriter, call int)
+	}{
+		{"api error", "1012 Request must contain one of files", func(w http.ResponseWriter, call int) {
+			if call == 2 {
+				w.Write([]byte(`{"success": false, "errors": [{"code": 1012, "message": "Request must contain one of files"}]}`))
+				return
+			}
+			w.Write([]byte(`{"success": true}`))
+		}},
+		{"http error", "429 Too Many Requests", func(w http.ResponseWriter, call int) {
+			if call == 2 {
+				http.Error(w, "rate limited", http.StatusTooManyRequests)
+				return
+			}
+			w.Write([]byte(`{"success": true}`))
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			stub, purger := newCloudflareStub(t, test.respond)
+			err := purger.Purge(context.Background(), testUrls(90))
+			if err == nil {
+				t.Fatal("Purge succeeded")
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("the error %q does not say why", err)
+			}
+			if len(stub.batches) != 2 {
+				t.Errorf("made %d calls, want 2", len(stub.batches))
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lorraxs/fivem_cdn_server/config"
+	"net/http"
+	"strings"
+)
+
+// Purger evicts absolute urls from a CDN that fronts the server.
+type Purger interface {
+	Purge(ctx context.Context, urls []string) error
+}
+
+// Noop is used when no CDN is configured.
+type Noop struct{}
+
+func (Noop) Purge(ctx context.Context, urls []string) error {
+	return nil
+}
+
+// Multi purges every url from each of its purgers and joins their errors.
+type Multi []Purger
+
+func (m Multi) Purge(ctx context.Context, urls []string) error {
+	errs := []error{}
+	for _, p := range m {
+		if err := p.Purge(ctx, urls); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// New builds the purgers listed in the [cdn] purgers key, e.g.
+// "cloudflare,webhook". An empty list disables purging.
+func New(cfg *config.Config) (Purger, error) {
+	client := &http.Client{Timeout: cfg.Cdn.Timeout}
+	purgers := Multi{}
+	for _, name := range cfg.Cdn.Purgers {
+		switch strings.TrimSpace(name) {
+		case "", "none":
+		case "cloudflare":
+			if cfg.Cdn.CloudflareZoneId == "" || cfg.Cdn.CloudflareToken == "" {
+				return nil, fmt.Errorf("cdn: cloudflare needs cloudflareZoneId and cloudflareToken")
+			}
+			purgers = append(purgers, NewCloudflare(client, cfg.Cdn.CloudflareApiUrl, cfg.Cdn.CloudflareZoneId, cfg.Cdn.CloudflareToken))
+		case "webhook":
+			if cfg.Cdn.WebhookUrl == "" {
+				return nil, fmt.Errorf("cdn: webhook needs webhookUrl")
+			}
+			purgers = append(purgers, NewWebhook(client, cfg.Cdn.WebhookUrl, cfg.Cdn.WebhookSecret))
+		default:
+			return nil, fmt.Errorf("cdn: unknown purger %q", name)
+		}
+	}
+	if len(purgers) == 0 {
+		return Noop{}, nil
+	}
+	if len(purgers) == 1 {
+		return purgers[0], nil
+	}
+	return purgers, nil
+}
+
+func checkResponse(resp *http.Response, what string) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("cdn: %s purge failed with %s", what, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Webhook posts {"urls": [...], "timestamp": unix} to a url of our own, for
+// CDNs without a built-in purger. With a secret the body is signed in the
+// X-Signature header as sha256=<hex hmac>.
+type Webhook struct {
+	client *http.Client
+	url    string
+	secret string
+}
+
+type webhookPayload struct {
+	Urls      []string `json:"urls"`
+	Timestamp int64    `json:"timestamp"`
+}
+
+func NewWebhook(client *http.Client, url, secret string) *Webhook {
+	return &Webhook{client: client, url: url, secret: secret}
+}
+
+func (p *Webhook) Purge(ctx context.Context, urls []string) error {
+	body, err := json.Marshal(webhookPayload{Urls: urls, Timestamp: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.secret != "" {
+		mac := hmac.New(sha256.New, []byte(p.secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, "webhook")
+}
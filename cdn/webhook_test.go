@@ -0,0 +1,79 @@
+package cdn
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookReceiver checks requests the way a receiving service would, the
+// signature over the raw body first.
+func webhookReceiver(t *testing.T, secret string, got *webhookPayload) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		signature := r.Header.Get("X-Signature")
+		if secret == "" {
+			if signature != "" {
+				t.Errorf("signed without a secret: %q", signature)
+			}
+		} else {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			if !hmac.Equal([]byte(signature), []byte(want)) {
+				t.Errorf("X-Signature is %q, want %q", signature, want)
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+		}
+		if err := json.Unmarshal(body, got); err != nil {
+			t.Errorf("decoding %q: %s", body, err)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookSignsTheBody(t *testing.T) {
+	for _, secret := range []string{"s3cr3t", ""} {
+		var got webhookPayload
+		server := webhookReceiver(t, secret, &got)
+		urls := testUrls(3)
+		if err := NewWebhook(server.Client(), server.URL, secret).Purge(context.Background(), urls); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got.Urls, ",") != strings.Join(urls, ",") {
+			t.Errorf("received %q, want %q", got.Urls, urls)
+		}
+		if d := time.Since(time.Unix(got.Timestamp, 0)); d < 0 || d > time.Minute {
+			t.Errorf("timestamp %d is not now", got.Timestamp)
+		}
+	}
+}
+
+func TestWebhookWrongSecretFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("expected"))
+		mac.Write(body)
+		if r.Header.Get("X-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	err := NewWebhook(server.Client(), server.URL, "other").Purge(context.Background(), testUrls(1))
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Purge with the wrong secret returned %v, want a 401", err)
+	}
+}
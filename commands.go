@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"lorraxs/fivem_cdn_server/auth"
+	"lorraxs/fivem_cdn_server/config"
+	"lorraxs/fivem_cdn_server/controllers"
+	"lorraxs/fivem_cdn_server/migrations"
+	"lorraxs/fivem_cdn_server/storage"
+	"strconv"
+	"time"
+)
+
+// runCommand handles the one-shot maintenance subcommands:
+//
+//	fivem_cdn_server migrate [up|down [steps]|status]
+//	fivem_cdn_server backfill
+//	fivem_cdn_server apikey create <name> <scope,scope|*> [ttl]
+//	fivem_cdn_server apikey list
+//	fivem_cdn_server apikey revoke <keyId>
+//	fivem_cdn_server gc [grace]
+func runCommand(ctx context.Context, args []string, db *sql.DB, store storage.Storage) error {
+	if args[0] != "migrate" {
+		if _, err := migrations.Up(ctx, db); err != nil {
+			return err
+		}
+	}
+	switch args[0] {
+	case "migrate":
+		return runMigrate(ctx, args[1:], db)
+	case "backfill":
+		c := controllers.NewUploadController()
+		c.Setup(ctx, controllers.Dependencies{DB: db, Storage: store})
+		count, err := c.BackfillClothing(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backfilled %d clothing items\n", count)
+		return nil
+	case "apikey":
+		return runApiKey(ctx, args[1:], db)
+	case "gc":
+		blobs, ok := store.(*storage.ContentAddressed)
+		if !ok {
+			return fmt.Errorf("gc needs contentAddressed = true in [storage]")
+		}
+		grace := config.GetConfig().Storage.GcGrace
+		if len(args) > 1 {
+			d, err := time.ParseDuration(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid grace %q: %w", args[1], err)
+			}
+			grace = d
+		}
+		removed, err := blobs.CollectGarbage(ctx, grace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d unreferenced blobs\n", removed)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func runApiKey(ctx context.Context, args []string, db *sql.DB) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: apikey create|list|revoke")
+	}
+	store := auth.NewStore(db)
+	switch args[0] {
+	case "create":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: apikey create <name> <scope,scope|*> [ttl]")
+		}
+		scopes, err := auth.ParseScopes(args[2])
+		if err != nil {
+			return err
+		}
+		var expiresAt *time.Time
+		if len(args) > 3 {
+			ttl, err := time.ParseDuration(args[3])
+			if err != nil {
+				return fmt.Errorf("invalid ttl %q: %w", args[3], err)
+			}
+			t := time.Now().Add(ttl)
+			expiresAt = &t
+		}
+		token, key, err := store.Create(ctx, args[1], scopes, expiresAt)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created key %s (%s)\n", key.KeyID, key.Name)
+		fmt.Println("Token (shown only once):", token)
+		return nil
+	case "list":
+		keys, err := store.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			state := "active"
+			if key.RevokedAt != nil {
+				state = "revoked"
+			} else if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+				state = "expired"
+			}
+			fmt.Printf("%s\t%s\t%v\t%s\n", key.KeyID, key.Name, key.Scopes, state)
+		}
+		return nil
+	case "revoke":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: apikey revoke <keyId>")
+		}
+		return store.Revoke(ctx, args[1])
+	default:
+		return fmt.Errorf("unknown apikey action %q", args[0])
+	}
+}
+
+func runMigrate(ctx context.Context, args []string, db *sql.DB) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "up":
+		ran, err := migrations.Up(ctx, db)
+		for _, m := range ran {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(ran) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		ran, err := migrations.Down(ctx, db, steps)
+		for _, m := range ran {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrations.GetStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q", action)
+	}
+}
@@ -1,7 +1,12 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -17,13 +22,172 @@ type HttpSection struct {
 }
 
 type MySQLSection struct {
-	Uri string `json:"uri"`
+	Uri         string `json:"uri"`
+	AutoMigrate bool   `json:"autoMigrate"`
+}
+
+type StorageSection struct {
+	Driver    string `json:"driver"`
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"-"`
+	Prefix    string `json:"prefix"`
+	PathStyle bool   `json:"pathStyle"`
+	// ContentAddressed stores each distinct file once by its SHA-256.
+	ContentAddressed bool          `json:"contentAddressed"`
+	GcInterval       time.Duration `json:"gcInterval"`
+	GcGrace          time.Duration `json:"gcGrace"`
+}
+
+type AuthSection struct {
+	// LegacySecret keeps accepting [app] secret in the Secret header, on by
+	// default when one is configured so upgraded servers keep working.
+	LegacySecret bool `json:"legacySecret"`
+}
+
+type SigningSection struct {
+	Secret               string        `json:"-"`
+	Ttl                  time.Duration `json:"ttl"`
+	ProtectedCollections []string      `json:"protectedCollections"`
+}
+
+type ImagesSection struct {
+	CachePath string `json:"cachePath"`
+	// CacheMaxSizeMb bounds the variant cache, the least recently served
+	// variants are evicted past it.
+	CacheMaxSizeMb  int `json:"cacheMaxSizeMb"`
+	MaxDimension    int `json:"maxDimension"`
+	DefaultQuality  int `json:"defaultQuality"`
+	MinParticleArea int `json:"minParticleArea"`
+	// SizeStep and QualityStep quantize the requested width, height and
+	// quality so the number of variants of a file stays small.
+	SizeStep    int `json:"sizeStep"`
+	QualityStep int `json:"qualityStep"`
+}
+
+type ThumbnailPreset struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type ThumbnailsSection struct {
+	Presets []ThumbnailPreset `json:"presets"`
+}
+
+type JobsSection struct {
+	Workers      int           `json:"workers"`
+	MaxAttempts  int           `json:"maxAttempts"`
+	PollInterval time.Duration `json:"pollInterval"`
+	// HeartbeatInterval is how often a worker marks its running job alive,
+	// a job missing three heartbeats is handed to another worker.
+	HeartbeatInterval time.Duration `json:"heartbeatInterval"`
+	AsyncUploads      bool          `json:"asyncUploads"`
+}
+
+type CdnSection struct {
+	// Purgers lists the purge integrations to call, cloudflare and/or webhook.
+	Purgers          []string      `json:"purgers"`
+	CloudflareApiUrl string        `json:"cloudflareApiUrl"`
+	CloudflareZoneId string        `json:"cloudflareZoneId"`
+	CloudflareToken  string        `json:"-"`
+	WebhookUrl       string        `json:"webhookUrl"`
+	WebhookSecret    string        `json:"-"`
+	Timeout          time.Duration `json:"timeout"`
+}
+
+type WebhooksSection struct {
+	Endpoints []string `json:"endpoints"`
+	// Events the endpoints subscribe to, "*" for all of them.
+	Events      []string      `json:"events"`
+	Secret      string        `json:"-"`
+	MaxAttempts int           `json:"maxAttempts"`
+	Timeout     time.Duration `json:"timeout"`
+}
+
+type WatcherSection struct {
+	// Enabled watches the upload directory of the local driver for files
+	// copied in or removed behind the server's back.
+	Enabled bool `json:"enabled"`
+	// Debounce is how long the directory has to be quiet before the changes
+	// are applied, so a bulk copy is handled as one batch.
+	Debounce time.Duration `json:"debounce"`
+}
+
+type LootSection struct {
+	// DefaultTable is the loot table rolled when a request names none. When
+	// it is not stored the built-in inverse price table is used.
+	DefaultTable string `json:"defaultTable"`
+	// AllowSeed accepts the seed parameter of the random endpoint, which
+	// makes rolls predictable. Only enable it for tests.
+	AllowSeed bool `json:"allowSeed"`
+}
+
+type EventsSection struct {
+	// BufferSize is how many events a reconnecting client can catch up on.
+	BufferSize int           `json:"bufferSize"`
+	Heartbeat  time.Duration `json:"heartbeat"`
+}
+
+type CacheSection struct {
+	// MaxAge applies to files requested by name, they can be overwritten.
+	MaxAge time.Duration `json:"maxAge"`
+	// HashedMaxAge applies to /static/hash urls, sent as immutable.
+	HashedMaxAge time.Duration `json:"hashedMaxAge"`
+}
+
+// CanvasProfile is the preview canvas used for one componentType, the
+// "default" profile applies to every other type.
+type CanvasProfile struct {
+	ComponentType string  `json:"componentType"`
+	AspectWidth   int     `json:"aspectWidth"`
+	AspectHeight  int     `json:"aspectHeight"`
+	Padding       float64 `json:"padding"`
+	Anchor        string  `json:"anchor"`
+}
+
+type CanvasSection struct {
+	Enabled  bool            `json:"enabled"`
+	Profiles []CanvasProfile `json:"profiles"`
+}
+
+// Profile returns the canvas profile of componentType, falling back to the
+// default profile.
+func (s CanvasSection) Profile(componentType string) (CanvasProfile, bool) {
+	var fallback *CanvasProfile
+	for i, profile := range s.Profiles {
+		if profile.ComponentType == componentType {
+			return profile, true
+		}
+		if profile.ComponentType == "default" {
+			fallback = &s.Profiles[i]
+		}
+	}
+	if fallback == nil {
+		return CanvasProfile{}, false
+	}
+	return *fallback, true
 }
 
 type Config struct {
-	App   AppSection   `json:"app"`
-	Http  HttpSection  `json:"http"`
-	MySQL MySQLSection `json:"mysql"`
+	App        AppSection        `json:"app"`
+	Http       HttpSection       `json:"http"`
+	MySQL      MySQLSection      `json:"mysql"`
+	Storage    StorageSection    `json:"storage"`
+	Auth       AuthSection       `json:"auth"`
+	Signing    SigningSection    `json:"signing"`
+	Images     ImagesSection     `json:"images"`
+	Thumbnails ThumbnailsSection `json:"thumbnails"`
+	Jobs       JobsSection       `json:"jobs"`
+	Canvas     CanvasSection     `json:"canvas"`
+	Cache      CacheSection      `json:"cache"`
+	Cdn        CdnSection        `json:"cdn"`
+	Webhooks   WebhooksSection   `json:"webhooks"`
+	Events     EventsSection     `json:"events"`
+	Watcher    WatcherSection    `json:"watcher"`
+	Loot       LootSection       `json:"loot"`
 }
 
 var lock = &sync.Mutex{}
@@ -47,6 +211,19 @@ func loadConfig() *Config {
 	appSection := iniData.Section("app")
 	httpSection := iniData.Section("http")
 	mysqlSection := iniData.Section("mysql")
+	storageSection := iniData.Section("storage")
+	authSection := iniData.Section("auth")
+	signingSection := iniData.Section("signing")
+	imagesSection := iniData.Section("images")
+	thumbnailsSection := iniData.Section("thumbnails")
+	jobsSection := iniData.Section("jobs")
+	canvasSection := iniData.Section("canvas")
+	cacheSection := iniData.Section("cache")
+	cdnSection := iniData.Section("cdn")
+	webhooksSection := iniData.Section("webhooks")
+	eventsSection := iniData.Section("events")
+	watcherSection := iniData.Section("watcher")
+	lootSection := iniData.Section("loot")
 
 	config.App = AppSection{
 		Secret:     appSection.Key("secret").String(),
@@ -57,12 +234,137 @@ func loadConfig() *Config {
 		Port: httpSection.Key("port").String(),
 	}
 	config.MySQL = MySQLSection{
-		Uri: mysqlSection.Key("uri").String(),
+		Uri:         mysqlSection.Key("uri").String(),
+		AutoMigrate: mysqlSection.Key("autoMigrate").MustBool(true),
+	}
+	config.Storage = StorageSection{
+		Driver:    storageSection.Key("driver").String(),
+		Endpoint:  storageSection.Key("endpoint").String(),
+		Region:    storageSection.Key("region").String(),
+		Bucket:    storageSection.Key("bucket").String(),
+		AccessKey: storageSection.Key("accessKey").String(),
+		SecretKey: storageSection.Key("secretKey").String(),
+		Prefix:    storageSection.Key("prefix").String(),
+		PathStyle: storageSection.Key("pathStyle").MustBool(true),
+
+		ContentAddressed: storageSection.Key("contentAddressed").MustBool(false),
+		GcInterval:       storageSection.Key("gcInterval").MustDuration(0),
+		GcGrace:          storageSection.Key("gcGrace").MustDuration(0),
+	}
+	config.Auth = AuthSection{
+		LegacySecret: authSection.Key("legacySecret").MustBool(config.App.Secret != ""),
+	}
+	config.Signing = SigningSection{
+		Secret:               signingSection.Key("secret").String(),
+		Ttl:                  signingSection.Key("ttl").MustDuration(time.Hour),
+		ProtectedCollections: signingSection.Key("protectedCollections").Strings(","),
+	}
+	config.Images = ImagesSection{
+		CachePath:       imagesSection.Key("cachePath").String(),
+		CacheMaxSizeMb:  imagesSection.Key("cacheMaxSizeMb").MustInt(0),
+		MaxDimension:    imagesSection.Key("maxDimension").MustInt(0),
+		DefaultQuality:  imagesSection.Key("defaultQuality").MustInt(0),
+		MinParticleArea: imagesSection.Key("minParticleArea").MustInt(-1),
+		SizeStep:        imagesSection.Key("sizeStep").MustInt(0),
+		QualityStep:     imagesSection.Key("qualityStep").MustInt(0),
+	}
+	config.Thumbnails = ThumbnailsSection{
+		Presets: parseThumbnailPresets(thumbnailsSection.Key("presets").Strings(",")),
+	}
+	config.Jobs = JobsSection{
+		Workers:           jobsSection.Key("workers").MustInt(0),
+		MaxAttempts:       jobsSection.Key("maxAttempts").MustInt(0),
+		PollInterval:      jobsSection.Key("pollInterval").MustDuration(0),
+		HeartbeatInterval: jobsSection.Key("heartbeatInterval").MustDuration(0),
+		AsyncUploads:      jobsSection.Key("asyncUploads").MustBool(false),
+	}
+	config.Canvas = CanvasSection{
+		Enabled:  canvasSection.Key("enabled").MustBool(false),
+		Profiles: parseCanvasProfiles(canvasSection.Key("profiles").Strings(",")),
+	}
+	config.Cache = CacheSection{
+		MaxAge:       cacheSection.Key("maxAge").MustDuration(-1),
+		HashedMaxAge: cacheSection.Key("hashedMaxAge").MustDuration(0),
+	}
+	config.Cdn = CdnSection{
+		Purgers:          cdnSection.Key("purgers").Strings(","),
+		CloudflareApiUrl: cdnSection.Key("cloudflareApiUrl").String(),
+		CloudflareZoneId: cdnSection.Key("cloudflareZoneId").String(),
+		CloudflareToken:  cdnSection.Key("cloudflareToken").String(),
+		WebhookUrl:       cdnSection.Key("webhookUrl").String(),
+		WebhookSecret:    cdnSection.Key("webhookSecret").String(),
+		Timeout:          cdnSection.Key("timeout").MustDuration(0),
+	}
+	config.Webhooks = WebhooksSection{
+		Endpoints:   webhooksSection.Key("endpoints").Strings(","),
+		Events:      webhooksSection.Key("events").Strings(","),
+		Secret:      webhooksSection.Key("secret").String(),
+		MaxAttempts: webhooksSection.Key("maxAttempts").MustInt(0),
+		Timeout:     webhooksSection.Key("timeout").MustDuration(0),
+	}
+	config.Events = EventsSection{
+		BufferSize: eventsSection.Key("bufferSize").MustInt(0),
+		Heartbeat:  eventsSection.Key("heartbeat").MustDuration(0),
+	}
+	config.Watcher = WatcherSection{
+		Enabled:  watcherSection.Key("enabled").MustBool(true),
+		Debounce: watcherSection.Key("debounce").MustDuration(0),
+	}
+	config.Loot = LootSection{
+		DefaultTable: lootSection.Key("defaultTable").String(),
+		AllowSeed:    lootSection.Key("allowSeed").MustBool(false),
 	}
 	checkConfig(iniData)
 	return config
 }
 
+// parseThumbnailPresets reads "name:size" or "name:widthxheight" entries,
+// a size of 0 means the original image.
+func parseThumbnailPresets(values []string) []ThumbnailPreset {
+	presets := []ThumbnailPreset{}
+	for _, value := range values {
+		name, size, ok := strings.Cut(strings.TrimSpace(value), ":")
+		if !ok || name == "" {
+			continue
+		}
+		preset := ThumbnailPreset{Name: name}
+		if w, h, ok := strings.Cut(size, "x"); ok {
+			preset.Width, _ = strconv.Atoi(w)
+			preset.Height, _ = strconv.Atoi(h)
+		} else {
+			preset.Width, _ = strconv.Atoi(size)
+			preset.Height = preset.Width
+		}
+		presets = append(presets, preset)
+	}
+	return presets
+}
+
+// parseCanvasProfiles reads "componentType:WxH:padding:anchor" entries,
+// padding is a percentage and anchor is center or bottom.
+func parseCanvasProfiles(values []string) []CanvasProfile {
+	profiles := []CanvasProfile{}
+	for _, value := range values {
+		parts := strings.Split(strings.TrimSpace(value), ":")
+		if len(parts) != 4 || parts[0] == "" {
+			continue
+		}
+		w, h, ok := strings.Cut(parts[1], "x")
+		if !ok {
+			continue
+		}
+		profile := CanvasProfile{ComponentType: parts[0], Anchor: parts[3]}
+		profile.AspectWidth, _ = strconv.Atoi(w)
+		profile.AspectHeight, _ = strconv.Atoi(h)
+		profile.Padding, _ = strconv.ParseFloat(parts[2], 64)
+		if profile.AspectWidth <= 0 || profile.AspectHeight <= 0 {
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles
+}
+
 func checkConfig(iniData *ini.File) *Config {
 	/* config.App = AppSection{
 		Secret: "default-secret",
@@ -124,6 +426,368 @@ func checkConfig(iniData *ini.File) *Config {
 			panic(err)
 		}
 	}
+	if !mysqlSection.HasKey("autoMigrate") {
+		config.MySQL.AutoMigrate = true
+		_, err = mysqlSection.NewKey("autoMigrate", "true")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Storage
+	storageSection, err := iniData.NewSection("storage")
+	if err != nil {
+		panic(err)
+	}
+	if config.Storage.Driver == "" {
+		config.Storage.Driver = "local"
+		_, err = storageSection.NewKey("driver", config.Storage.Driver)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !storageSection.HasKey("contentAddressed") {
+		_, err = storageSection.NewKey("contentAddressed", "false")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Storage.GcInterval <= 0 {
+		config.Storage.GcInterval = time.Hour
+		_, err = storageSection.NewKey("gcInterval", "1h")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Storage.GcGrace <= 0 {
+		config.Storage.GcGrace = 10 * time.Minute
+		_, err = storageSection.NewKey("gcGrace", "10m")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Auth
+	authSection, err := iniData.NewSection("auth")
+	if err != nil {
+		panic(err)
+	}
+	if !authSection.HasKey("legacySecret") {
+		_, err = authSection.NewKey("legacySecret", strconv.FormatBool(config.Auth.LegacySecret))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Signing
+	signingSection, err := iniData.NewSection("signing")
+	if err != nil {
+		panic(err)
+	}
+	if config.Signing.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		config.Signing.Secret = hex.EncodeToString(b)
+		_, err = signingSection.NewKey("secret", config.Signing.Secret)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !signingSection.HasKey("ttl") {
+		config.Signing.Ttl = time.Hour
+		_, err = signingSection.NewKey("ttl", "1h")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !signingSection.HasKey("protectedCollections") {
+		_, err = signingSection.NewKey("protectedCollections", "")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Images
+	imagesSection, err := iniData.NewSection("images")
+	if err != nil {
+		panic(err)
+	}
+	if config.Images.CachePath == "" {
+		config.Images.CachePath = "cache"
+		_, err = imagesSection.NewKey("cachePath", config.Images.CachePath)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.MaxDimension <= 0 {
+		config.Images.MaxDimension = 2048
+		_, err = imagesSection.NewKey("maxDimension", "2048")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.DefaultQuality <= 0 || config.Images.DefaultQuality > 100 {
+		config.Images.DefaultQuality = 80
+		_, err = imagesSection.NewKey("defaultQuality", "80")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.MinParticleArea < 0 {
+		config.Images.MinParticleArea = 64
+		_, err = imagesSection.NewKey("minParticleArea", "64")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.CacheMaxSizeMb <= 0 {
+		config.Images.CacheMaxSizeMb = 1024
+		_, err = imagesSection.NewKey("cacheMaxSizeMb", "1024")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.SizeStep <= 0 {
+		config.Images.SizeStep = 64
+		_, err = imagesSection.NewKey("sizeStep", "64")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Images.QualityStep <= 0 || config.Images.QualityStep > 100 {
+		config.Images.QualityStep = 10
+		_, err = imagesSection.NewKey("qualityStep", "10")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Thumbnails
+	thumbnailsSection, err := iniData.NewSection("thumbnails")
+	if err != nil {
+		panic(err)
+	}
+	if !thumbnailsSection.HasKey("presets") {
+		presets := "thumb:128,card:256,full:0"
+		config.Thumbnails.Presets = parseThumbnailPresets(strings.Split(presets, ","))
+		_, err = thumbnailsSection.NewKey("presets", presets)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Jobs
+	jobsSection, err := iniData.NewSection("jobs")
+	if err != nil {
+		panic(err)
+	}
+	if config.Jobs.Workers <= 0 {
+		config.Jobs.Workers = 2
+		_, err = jobsSection.NewKey("workers", "2")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Jobs.MaxAttempts <= 0 {
+		config.Jobs.MaxAttempts = 3
+		_, err = jobsSection.NewKey("maxAttempts", "3")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Jobs.PollInterval <= 0 {
+		config.Jobs.PollInterval = 2 * time.Second
+		_, err = jobsSection.NewKey("pollInterval", "2s")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Jobs.HeartbeatInterval <= 0 {
+		config.Jobs.HeartbeatInterval = 10 * time.Second
+		_, err = jobsSection.NewKey("heartbeatInterval", "10s")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !jobsSection.HasKey("asyncUploads") {
+		_, err = jobsSection.NewKey("asyncUploads", "false")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Canvas
+	canvasSection, err := iniData.NewSection("canvas")
+	if err != nil {
+		panic(err)
+	}
+	if !canvasSection.HasKey("enabled") {
+		_, err = canvasSection.NewKey("enabled", "false")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !canvasSection.HasKey("profiles") {
+		profiles := "default:1x1:8:center,component:1x1:6:bottom,prop:1x1:12:center"
+		config.Canvas.Profiles = parseCanvasProfiles(strings.Split(profiles, ","))
+		_, err = canvasSection.NewKey("profiles", profiles)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Cache
+	cacheSection, err := iniData.NewSection("cache")
+	if err != nil {
+		panic(err)
+	}
+	if config.Cache.MaxAge < 0 {
+		config.Cache.MaxAge = 5 * time.Minute
+		_, err = cacheSection.NewKey("maxAge", "5m")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Cache.HashedMaxAge <= 0 {
+		config.Cache.HashedMaxAge = 365 * 24 * time.Hour
+		_, err = cacheSection.NewKey("hashedMaxAge", "8760h")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Cdn
+	cdnSection, err := iniData.NewSection("cdn")
+	if err != nil {
+		panic(err)
+	}
+	for _, key := range []string{"purgers", "cloudflareZoneId", "cloudflareToken", "webhookUrl", "webhookSecret"} {
+		if !cdnSection.HasKey(key) {
+			_, err = cdnSection.NewKey(key, "")
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+	if config.Cdn.CloudflareApiUrl == "" {
+		config.Cdn.CloudflareApiUrl = "https://api.cloudflare.com/client/v4"
+		_, err = cdnSection.NewKey("cloudflareApiUrl", config.Cdn.CloudflareApiUrl)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Cdn.Timeout <= 0 {
+		config.Cdn.Timeout = 10 * time.Second
+		_, err = cdnSection.NewKey("timeout", "10s")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Webhooks
+	webhooksSection, err := iniData.NewSection("webhooks")
+	if err != nil {
+		panic(err)
+	}
+	if !webhooksSection.HasKey("endpoints") {
+		_, err = webhooksSection.NewKey("endpoints", "")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if len(config.Webhooks.Events) == 0 {
+		config.Webhooks.Events = []string{"*"}
+		_, err = webhooksSection.NewKey("events", "*")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Webhooks.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		config.Webhooks.Secret = hex.EncodeToString(b)
+		_, err = webhooksSection.NewKey("secret", config.Webhooks.Secret)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Webhooks.MaxAttempts <= 0 {
+		config.Webhooks.MaxAttempts = 8
+		_, err = webhooksSection.NewKey("maxAttempts", "8")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Webhooks.Timeout <= 0 {
+		config.Webhooks.Timeout = 10 * time.Second
+		_, err = webhooksSection.NewKey("timeout", "10s")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Events
+	eventsSection, err := iniData.NewSection("events")
+	if err != nil {
+		panic(err)
+	}
+	if config.Events.BufferSize <= 0 {
+		config.Events.BufferSize = 1024
+		_, err = eventsSection.NewKey("bufferSize", "1024")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Events.Heartbeat <= 0 {
+		config.Events.Heartbeat = 15 * time.Second
+		_, err = eventsSection.NewKey("heartbeat", "15s")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Watcher
+	watcherSection, err := iniData.NewSection("watcher")
+	if err != nil {
+		panic(err)
+	}
+	if !watcherSection.HasKey("enabled") {
+		config.Watcher.Enabled = true
+		_, err = watcherSection.NewKey("enabled", "true")
+		if err != nil {
+			panic(err)
+		}
+	}
+	if config.Watcher.Debounce <= 0 {
+		config.Watcher.Debounce = 500 * time.Millisecond
+		_, err = watcherSection.NewKey("debounce", "500ms")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//Loot
+	lootSection, err := iniData.NewSection("loot")
+	if err != nil {
+		panic(err)
+	}
+	if config.Loot.DefaultTable == "" {
+		config.Loot.DefaultTable = "default"
+		_, err = lootSection.NewKey("defaultTable", config.Loot.DefaultTable)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if !lootSection.HasKey("allowSeed") {
+		_, err = lootSection.NewKey("allowSeed", "false")
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	//SAVE
 	err = iniData.SaveTo("config.ini")
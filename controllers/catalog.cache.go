@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type componentKey struct {
+	ComponentType string
+	ComponentId   int
+}
+
+// CatalogSnapshot is an immutable view of the clothing catalog with its
+// lookup indexes. Neither the snapshot nor the items it holds are modified
+// once published, changes build a new snapshot.
+//
+// Items keep the order they were added in, an item that is replaced keeps
+// its position, in the catalog and in its buckets.
+type CatalogSnapshot struct {
+	items        []*ClothingItem
+	byHash       map[string]*ClothingItem
+	byFileName   map[string]*ClothingItem
+	byBaseName   map[string]*ClothingItem
+	byCollection map[string][]*ClothingItem
+	byComponent  map[componentKey][]*ClothingItem
+	byGender     map[int][]*ClothingItem
+}
+
+func newCatalogSnapshot(items []*ClothingItem) *CatalogSnapshot {
+	s := &CatalogSnapshot{
+		items:        items,
+		byHash:       make(map[string]*ClothingItem, len(items)),
+		byFileName:   make(map[string]*ClothingItem, len(items)),
+		byBaseName:   make(map[string]*ClothingItem, len(items)),
+		byCollection: make(map[string][]*ClothingItem),
+		byComponent:  make(map[componentKey][]*ClothingItem),
+		byGender:     make(map[int][]*ClothingItem),
+	}
+	for _, item := range items {
+		s.byHash[item.Hash] = item
+		s.byFileName[item.FileName] = item
+		s.byBaseName[removedExt(item.FileName)] = item
+		s.byCollection[item.CollectionName] = append(s.byCollection[item.CollectionName], item)
+		key := componentKey{item.ComponentType, item.ComponentId}
+		s.byComponent[key] = append(s.byComponent[key], item)
+		s.byGender[item.Gender] = append(s.byGender[item.Gender], item)
+	}
+	return s
+}
+
+func (s *CatalogSnapshot) Len() int {
+	return len(s.items)
+}
+
+// The slices returned below are shared with the snapshot, callers must not
+// modify them.
+
+// Items lists the whole catalog.
+func (s *CatalogSnapshot) Items() []*ClothingItem {
+	return s.items
+}
+
+func (s *CatalogSnapshot) ByHash(hash string) (*ClothingItem, bool) {
+	item, ok := s.byHash[hash]
+	return item, ok
+}
+
+func (s *CatalogSnapshot) ByFileName(fileName string) (*ClothingItem, bool) {
+	item, ok := s.byFileName[fileName]
+	return item, ok
+}
+
+// ByBaseName looks an item up by its file name without extension.
+func (s *CatalogSnapshot) ByBaseName(baseName string) (*ClothingItem, bool) {
+	item, ok := s.byBaseName[baseName]
+	return item, ok
+}
+
+func (s *CatalogSnapshot) ByCollection(collection string) []*ClothingItem {
+	return s.byCollection[collection]
+}
+
+func (s *CatalogSnapshot) ByComponent(componentType string, componentId int) []*ClothingItem {
+	return s.byComponent[componentKey{componentType, componentId}]
+}
+
+func (s *CatalogSnapshot) ByGender(gender int) []*ClothingItem {
+	return s.byGender[gender]
+}
+
+// clone copies the indexes of s, the item pointers and bucket slices are
+// shared until a bucket is changed.
+func (s *CatalogSnapshot) clone() *CatalogSnapshot {
+	return &CatalogSnapshot{
+		items:        slices.Clone(s.items),
+		byHash:       maps.Clone(s.byHash),
+		byFileName:   maps.Clone(s.byFileName),
+		byBaseName:   maps.Clone(s.byBaseName),
+		byCollection: maps.Clone(s.byCollection),
+		byComponent:  maps.Clone(s.byComponent),
+		byGender:     maps.Clone(s.byGender),
+	}
+}
+
+// withItem returns a copy of list with item appended, list itself may be
+// shared with an older snapshot and is never written to.
+func withItem(list []*ClothingItem, item *ClothingItem) []*ClothingItem {
+	return append(slices.Clip(list), item)
+}
+
+func withoutItem(list []*ClothingItem, item *ClothingItem) []*ClothingItem {
+	out := make([]*ClothingItem, 0, len(list))
+	for _, other := range list {
+		if other != item {
+			out = append(out, other)
+		}
+	}
+	return out
+}
+
+func addToBucket[K comparable](index map[K][]*ClothingItem, key K, item *ClothingItem) {
+	index[key] = withItem(index[key], item)
+}
+
+func removeFromBucket[K comparable](index map[K][]*ClothingItem, key K, item *ClothingItem) {
+	list := withoutItem(index[key], item)
+	if len(list) == 0 {
+		delete(index, key)
+		return
+	}
+	index[key] = list
+}
+
+// replaceInBucket swaps old for item in a copy of the bucket of key, or
+// moves item to the bucket of newKey when it changed buckets.
+func replaceInBucket[K comparable](index map[K][]*ClothingItem, key, newKey K, old, item *ClothingItem) {
+	if key != newKey {
+		removeFromBucket(index, key, old)
+		addToBucket(index, newKey, item)
+		return
+	}
+	list := slices.Clone(index[key])
+	if i := slices.Index(list, old); i >= 0 {
+		list[i] = item
+	} else {
+		list = append(list, item)
+	}
+	index[key] = list
+}
+
+// add indexes item on a snapshot that has not been published yet.
+func (s *CatalogSnapshot) add(item *ClothingItem) {
+	s.items = append(s.items, item)
+	s.byHash[item.Hash] = item
+	s.byFileName[item.FileName] = item
+	s.byBaseName[removedExt(item.FileName)] = item
+	addToBucket(s.byCollection, item.CollectionName, item)
+	addToBucket(s.byComponent, componentKey{item.ComponentType, item.ComponentId}, item)
+	addToBucket(s.byGender, item.Gender, item)
+}
+
+// replace swaps old for item, keeping its position.
+func (s *CatalogSnapshot) replace(old, item *ClothingItem) {
+	if i := slices.Index(s.items, old); i >= 0 {
+		s.items[i] = item
+	}
+	s.removeLookups(old)
+	s.byHash[item.Hash] = item
+	s.byFileName[item.FileName] = item
+	s.byBaseName[removedExt(item.FileName)] = item
+	replaceInBucket(s.byCollection, old.CollectionName, item.CollectionName, old, item)
+	replaceInBucket(s.byComponent, componentKey{old.ComponentType, old.ComponentId}, componentKey{item.ComponentType, item.ComponentId}, old, item)
+	replaceInBucket(s.byGender, old.Gender, item.Gender, old, item)
+}
+
+func (s *CatalogSnapshot) remove(item *ClothingItem) {
+	s.items = withoutItem(s.items, item)
+	s.removeLookups(item)
+	removeFromBucket(s.byCollection, item.CollectionName, item)
+	removeFromBucket(s.byComponent, componentKey{item.ComponentType, item.ComponentId}, item)
+	removeFromBucket(s.byGender, item.Gender, item)
+}
+
+// removeLookups drops item from the hash and name indexes, unless another
+// item took its key since.
+func (s *CatalogSnapshot) removeLookups(item *ClothingItem) {
+	if s.byHash[item.Hash] == item {
+		delete(s.byHash, item.Hash)
+	}
+	if s.byFileName[item.FileName] == item {
+		delete(s.byFileName, item.FileName)
+	}
+	if baseName := removedExt(item.FileName); s.byBaseName[baseName] == item {
+		delete(s.byBaseName, baseName)
+	}
+}
+
+// CatalogCache holds the current catalog snapshot. Readers grab a snapshot
+// once per request and keep a consistent view while writers swap in a new
+// one atomically.
+//
+// Writers either Replace the whole catalog or apply a single change with
+// Put, Remove and Reprice. A change copies the indexes under the writer
+// lock but only touches the buckets of the items involved, so it never has
+// to go back to the database for the rest of the catalog.
+type CatalogCache struct {
+	// mu serializes writers, readers never take it.
+	mu      sync.Mutex
+	current atomic.Pointer[CatalogSnapshot]
+}
+
+func NewCatalogCache() *CatalogCache {
+	cache := &CatalogCache{}
+	cache.current.Store(newCatalogSnapshot(nil))
+	return cache
+}
+
+func (c *CatalogCache) Snapshot() *CatalogSnapshot {
+	return c.current.Load()
+}
+
+// Replace publishes a snapshot of items, which must not be modified
+// afterwards.
+func (c *CatalogCache) Replace(items []*ClothingItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current.Store(newCatalogSnapshot(items))
+}
+
+// update publishes the snapshot change builds from a copy of the current
+// one. change returns false to leave the current snapshot in place.
+func (c *CatalogCache) update(change func(s *CatalogSnapshot) bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := c.current.Load().clone()
+	if !change(next) {
+		return false
+	}
+	c.current.Store(next)
+	return true
+}
+
+// Put adds item, or replaces the item with the same hash or file name.
+func (c *CatalogCache) Put(item *ClothingItem) {
+	c.update(func(s *CatalogSnapshot) bool {
+		old, ok := s.byHash[item.Hash]
+		if !ok {
+			old, ok = s.byFileName[item.FileName]
+		}
+		if ok {
+			if other, found := s.byFileName[item.FileName]; found && other != old {
+				s.remove(other)
+			}
+			s.replace(old, item)
+			return true
+		}
+		s.add(item)
+		return true
+	})
+}
+
+// Remove drops the item stored under fileName and returns it.
+func (c *CatalogCache) Remove(fileName string) (*ClothingItem, bool) {
+	var removed *ClothingItem
+	ok := c.update(func(s *CatalogSnapshot) bool {
+		removed = s.byFileName[fileName]
+		if removed == nil {
+			return false
+		}
+		s.remove(removed)
+		return true
+	})
+	return removed, ok
+}
+
+// Reprice sets the price of the item with hash. Prices may be set before
+// the texture is uploaded, in which case there is nothing to update and the
+// price is picked up when the item is loaded.
+func (c *CatalogCache) Reprice(hash string, price float64) bool {
+	return c.update(func(s *CatalogSnapshot) bool {
+		old, ok := s.byHash[hash]
+		if !ok || old.Price == price {
+			return false
+		}
+		item := *old
+		item.Price = price
+		s.replace(old, &item)
+		return true
+	})
+}
@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testItem(i int) *ClothingItem {
+	return &ClothingItem{
+		CollectionName: fmt.Sprintf("collection%d", i%3),
+		Gender:         i % 2,
+		ComponentType:  "component",
+		ComponentId:    i % 5,
+		Hash:           fmt.Sprintf("hash%d", i),
+		FileName:       fmt.Sprintf("file%d.webp", i),
+	}
+}
+
+func TestCatalogCache(t *testing.T) {
+	cache := NewCatalogCache()
+	items := []*ClothingItem{}
+	for i := 0; i < 100; i++ {
+		items = append(items, testItem(i))
+	}
+	cache.Replace(items)
+	before := cache.Snapshot()
+
+	cache.Put(testItem(100))
+	cache.Reprice("hash7", 12)
+	if _, ok := cache.Remove("file3.webp"); !ok {
+		t.Fatal("removing a stored file failed")
+	}
+	if _, ok := cache.Remove("file3.webp"); ok {
+		t.Fatal("removing a file twice succeeded")
+	}
+
+	s := cache.Snapshot()
+	if s.Len() != 100 || before.Len() != 100 {
+		t.Fatalf("got %d items, %d before, want 100 for both", s.Len(), before.Len())
+	}
+	if _, ok := before.ByFileName("file3.webp"); !ok {
+		t.Fatal("a published snapshot changed")
+	}
+	if _, ok := s.ByHash("hash3"); ok {
+		t.Fatal("a removed item is still indexed by hash")
+	}
+	if item, ok := s.ByHash("hash7"); !ok || item.Price != 12 || s.Items()[6] != item {
+		t.Fatal("a repriced item should keep its position")
+	}
+	if item, ok := s.ByBaseName("file100"); !ok || s.Items()[99] != item {
+		t.Fatal("an added item should come last")
+	}
+	for _, item := range s.ByComponent("component", 3) {
+		if item.ComponentId != 3 || item.Hash == "hash3" {
+			t.Fatalf("component bucket holds %s", item.Hash)
+		}
+	}
+	if got := len(s.ByCollection("collection1")); got != 34 {
+		t.Fatalf("collection1 holds %d items, want 34", got)
+	}
+	if got := len(s.ByGender(1)); got != 49 {
+		t.Fatalf("gender 1 holds %d items, want 49", got)
+	}
+}
+
+func hashes(items []*ClothingItem) string {
+	out := []string{}
+	for _, item := range items {
+		out = append(out, item.Hash)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestCatalogCachePut(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		item  func() *ClothingItem
+		items string
+		// component 0 bucket
+		bucket string
+	}{
+		{"new item comes last", func() *ClothingItem { return testItem(5) }, "hash0,hash1,hash2,hash3,hash4,hash5", "hash0,hash5"},
+		{"same hash keeps its place", func() *ClothingItem {
+			item := testItem(0)
+			item.FileName = "renamed.webp"
+			return item
+		}, "hash0,hash1,hash2,hash3,hash4", "hash0"},
+		{"same file name keeps its place", func() *ClothingItem {
+			item := testItem(2)
+			item.Hash, item.ComponentId = "rehashed", 0
+			return item
+		}, "hash0,hash1,rehashed,hash3,hash4", "hash0,rehashed"},
+		{"a hash and a file name of two items", func() *ClothingItem {
+			item := testItem(1)
+			item.FileName = "file3.webp"
+			return item
+		}, "hash0,hash1,hash2,hash4", "hash0"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cache := NewCatalogCache()
+			items := []*ClothingItem{}
+			for i := 0; i < 5; i++ {
+				items = append(items, testItem(i))
+			}
+			cache.Replace(items)
+			before := cache.Snapshot()
+
+			cache.Put(test.item())
+			s := cache.Snapshot()
+			if got := hashes(s.Items()); got != test.items {
+				t.Errorf("items are %s, want %s", got, test.items)
+			}
+			if got := hashes(s.ByComponent("component", 0)); got != test.bucket {
+				t.Errorf("component 0 holds %s, want %s", got, test.bucket)
+			}
+			if err := checkSnapshot(s); err != nil {
+				t.Error(err)
+			}
+			if got := hashes(before.Items()); got != "hash0,hash1,hash2,hash3,hash4" {
+				t.Errorf("the previous snapshot changed to %s", got)
+			}
+			if got := hashes(before.ByComponent("component", 0)); got != "hash0" {
+				t.Errorf("the previous component 0 bucket changed to %s", got)
+			}
+			if err := checkSnapshot(before); err != nil {
+				t.Errorf("previous snapshot: %s", err)
+			}
+		})
+	}
+}
+
+func TestCatalogCacheRemoveEmptiesBuckets(t *testing.T) {
+	cache := NewCatalogCache()
+	cache.Replace([]*ClothingItem{testItem(0), testItem(1)})
+	cache.Remove("file1.webp")
+	s := cache.Snapshot()
+	if _, ok := s.byComponent[componentKey{"component", 1}]; ok {
+		t.Fatal("the bucket of the removed item was kept")
+	}
+	if _, ok := s.byGender[1]; ok {
+		t.Fatal("the gender bucket of the removed item was kept")
+	}
+	if _, ok := s.ByBaseName("file1"); ok {
+		t.Fatal("the removed item is still indexed by base name")
+	}
+}
+
+// checkSnapshot reports the first way s disagrees with itself: every listed
+// item has to be reachable through each index and the indexes must not hold
+// anything else.
+func checkSnapshot(s *CatalogSnapshot) error {
+	items := s.Items()
+	if len(items) != s.Len() {
+		return fmt.Errorf("snapshot lists %d items but has %d", len(items), s.Len())
+	}
+	collections, components, genders := map[string]int{}, map[int]int{}, map[int]int{}
+	for _, item := range items {
+		if found, ok := s.ByHash(item.Hash); !ok || found != item {
+			return fmt.Errorf("%s is listed but not indexed by hash", item.Hash)
+		}
+		if found, ok := s.ByFileName(item.FileName); !ok || found != item {
+			return fmt.Errorf("%s is listed but not indexed by file name", item.FileName)
+		}
+		if found, ok := s.ByBaseName(removedExt(item.FileName)); !ok || found != item {
+			return fmt.Errorf("%s is listed but not indexed by base name", item.FileName)
+		}
+		collections[item.CollectionName]++
+		components[item.ComponentId]++
+		genders[item.Gender]++
+	}
+	for collection, n := range collections {
+		if got := len(s.ByCollection(collection)); got != n {
+			return fmt.Errorf("%s indexes %d items, want %d", collection, got, n)
+		}
+	}
+	for id := 0; id < 5; id++ {
+		if got := len(s.ByComponent("component", id)); got != components[id] {
+			return fmt.Errorf("component %d indexes %d items, want %d", id, got, components[id])
+		}
+	}
+	for gender := 0; gender < 2; gender++ {
+		if got := len(s.ByGender(gender)); got != genders[gender] {
+			return fmt.Errorf("gender %d indexes %d items, want %d", gender, got, genders[gender])
+		}
+	}
+	return nil
+}
+
+// readSnapshots checks snapshots until done is closed.
+func readSnapshots(t *testing.T, cache *CatalogCache, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err := checkSnapshot(cache.Snapshot()); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// TestCatalogCacheConcurrent is meant for go test -race: writers Put,
+// Reprice and Remove while readers walk snapshots, each of which has to
+// stay consistent.
+func TestCatalogCacheConcurrent(t *testing.T) {
+	cache := NewCatalogCache()
+	const writers, readers, rounds = 4, 4, 500
+	var writing, reading sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			readSnapshots(t, cache, done)
+		}()
+	}
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(w int) {
+			defer writing.Done()
+			for i := 0; i < rounds; i++ {
+				item := testItem(w*rounds + i)
+				cache.Put(item)
+				cache.Reprice(item.Hash, float64(i))
+				if i%3 == 0 {
+					cache.Remove(item.FileName)
+				}
+			}
+		}(w)
+	}
+	writing.Wait()
+	close(done)
+	reading.Wait()
+	want := writers * (rounds - (rounds+2)/3)
+	if got := cache.Snapshot().Len(); got != want {
+		t.Fatalf("got %d items, want %d", got, want)
+	}
+	if err := checkSnapshot(cache.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCatalogCacheConcurrentReplace reloads the whole catalog in the middle
+// of single changes, which land either before or after a reload but never
+// leave half of one behind.
+func TestCatalogCacheConcurrentReplace(t *testing.T) {
+	cache := NewCatalogCache()
+	base := []*ClothingItem{}
+	for i := 0; i < 200; i++ {
+		base = append(base, testItem(i))
+	}
+	cache.Replace(base)
+	const writers, readers, rounds = 4, 4, 300
+	var writing, reading sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		reading.Add(1)
+		go func() {
+			defer reading.Done()
+			readSnapshots(t, cache, done)
+		}()
+	}
+	writing.Add(1)
+	go func() {
+		defer writing.Done()
+		for i := 0; i < rounds/10; i++ {
+			// Replace takes ownership of the slice, hand it a fresh one
+			cache.Replace(append([]*ClothingItem(nil), base...))
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		writing.Add(1)
+		go func(w int) {
+			defer writing.Done()
+			for i := 0; i < rounds; i++ {
+				// overwrite items of the base catalog and add new ones
+				existing := testItem(w*50 + i%50)
+				cache.Reprice(existing.Hash, float64(i))
+				cache.Put(testItem(1000 + w*rounds + i))
+				if i%2 == 0 {
+					cache.Remove(existing.FileName)
+				}
+			}
+		}(w)
+	}
+	writing.Wait()
+	close(done)
+	reading.Wait()
+	if err := checkSnapshot(cache.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"lorraxs/fivem_cdn_server/storage"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileHolds counts the operations the server has running on each file. A
+// file stays held for a while after the last one releases it, the watcher
+// only gets to the events of those writes once the directory has been quiet
+// for the debounce interval.
+type fileHolds struct {
+	mu    sync.Mutex
+	holds map[string]*fileHold
+}
+
+type fileHold struct {
+	count int
+	until time.Time
+}
+
+func (h *fileHolds) hold(name string, linger time.Duration) (release func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.holds == nil {
+		h.holds = make(map[string]*fileHold)
+	}
+	hold := h.holds[name]
+	if hold == nil {
+		hold = &fileHold{}
+		h.holds[name] = hold
+	}
+	hold.count++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if hold.count--; hold.count > 0 {
+				return
+			}
+			hold.until = time.Now().Add(linger)
+			time.AfterFunc(linger, func() { h.expire(name, hold) })
+		})
+	}
+}
+
+// expire forgets hold once nothing holds it and its linger is over, a
+// later hold of the same name pushes that back.
+func (h *fileHolds) expire(name string, hold *fileHold) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.holds[name] == hold && hold.count == 0 && !time.Now().Before(hold.until) {
+		delete(h.holds, name)
+	}
+}
+
+func (h *fileHolds) held(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hold := h.holds[name]
+	return hold != nil && (hold.count > 0 || time.Now().Before(hold.until))
+}
+
+// holdFile marks name as being changed by the server itself until release
+// is called and for two debounce intervals after, the watcher leaves such
+// files to the request changing them. The timer of the watcher restarts on
+// the last event of the write, which can arrive just after the release.
+func (c *UploadController) holdFile(name string) (release func()) {
+	return c.held.hold(name, 2*c.Config.Watcher.Debounce)
+}
+
+// watchUploads keeps the catalog in sync with files copied into or removed
+// from the upload directory without going through the API, e.g. by rsync.
+// Events are collected until the directory has been quiet for the debounce
+// interval and every changed file is then handled once. Only the top level
+// of the directory is watched, nested directories hold derived files.
+func (c *UploadController) watchUploads(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(c.Config.App.UploadPath); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		changed := make(map[string]bool)
+		timer := time.NewTimer(c.Config.Watcher.Debounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Base(event.Name)
+				if strings.HasPrefix(name, ".") || event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				changed[name] = true
+				timer.Reset(c.Config.Watcher.Debounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				fmt.Println("Error watching the upload directory:", err)
+			case <-timer.C:
+				for name := range changed {
+					if err := c.syncFile(ctx, name); err != nil {
+						fmt.Printf("Error syncing %s: %s\n", name, err)
+					}
+				}
+				changed = make(map[string]bool)
+			}
+		}
+	}()
+	return nil
+}
+
+// syncFile brings the catalog in line with the current state of a top level
+// file. Files the server wrote itself are either still held or already in
+// the catalog with the same size, and are left alone.
+func (c *UploadController) syncFile(ctx context.Context, name string) error {
+	if c.held.held(name) {
+		return nil
+	}
+	info, err := c.Storage.Stat(ctx, name)
+	if errors.Is(err, storage.ErrNotFound) {
+		if c.findByFileName(name) == nil {
+			return nil
+		}
+		fmt.Printf("Removed out of band: %s\n", name)
+		return c.forgetFile(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(filepath.Ext(name)) != ".webp" {
+		return nil
+	}
+	clothing, ok := parseClothingFileName(name)
+	if !ok {
+		return nil
+	}
+	if item, err := c.GetClothingItem(ctx, clothing.Hash()); err == nil && item.FileName == name && int64(item.Size) == info.Size {
+		c.Catalog.Put(item)
+		return nil
+	}
+
+	obj, err := c.Storage.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(obj)
+	obj.Close()
+	if err != nil {
+		return fmt.Errorf("decoding the image: %w", err)
+	}
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(".webp")
+	}
+	fmt.Printf("Added out of band: %s\n", name)
+	return c.finishStore(ctx, &StoredFile{Name: name, Size: info.Size, Mime: contentType, Clothing: &clothing}, img)
+}
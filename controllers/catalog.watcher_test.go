@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileHolds(t *testing.T) {
+	const linger = 50 * time.Millisecond
+	var holds fileHolds
+	first := holds.hold("a.webp", linger)
+	second := holds.hold("a.webp", linger)
+
+	first()
+	// releasing twice must not drop the hold of the other operation
+	first()
+	if !holds.held("a.webp") {
+		t.Fatal("releasing one of two holds released the file")
+	}
+	second()
+	if !holds.held("a.webp") {
+		t.Fatal("the file was released before its events were handled")
+	}
+	if holds.held("b.webp") {
+		t.Fatal("a file nobody held is held")
+	}
+
+	time.Sleep(2 * linger)
+	if holds.held("a.webp") {
+		t.Fatal("the file is still held after the linger")
+	}
+	holds.mu.Lock()
+	defer holds.mu.Unlock()
+	if len(holds.holds) != 0 {
+		t.Fatalf("%d expired holds were kept", len(holds.holds))
+	}
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"lorraxs/fivem_cdn_server/utils"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ClothingFile is the catalog identity of an uploaded texture.
+type ClothingFile struct {
+	CollectionName string
+	Gender         string
+	ComponentType  string
+	ComponentId    string
+	DrawableId     string
+	TextureId      string
+}
+
+func (f ClothingFile) Hash() string {
+	return generateTextureHash(f.CollectionName, f.ComponentType, f.ComponentId, f.DrawableId, f.TextureId, f.Gender)
+}
+
+func (f ClothingFile) valid() bool {
+	if f.CollectionName == "" || f.ComponentType == "" || f.Gender == "" {
+		return false
+	}
+	for _, s := range []string{f.ComponentId, f.DrawableId, f.TextureId} {
+		if _, err := strconv.Atoi(s); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseClothingFileName splits a collection-gender-componentType-componentId-drawableId-textureId
+// file name. The collection name itself may contain "-".
+func parseClothingFileName(fileName string) (ClothingFile, bool) {
+	name := removedExt(filepath.Base(fileName))
+	parts := strings.Split(name, "-")
+	if len(parts) < 6 {
+		return ClothingFile{}, false
+	}
+	file := ClothingFile{
+		CollectionName: strings.Join(parts[:len(parts)-5], "-"),
+		Gender:         parts[len(parts)-5],
+		ComponentType:  parts[len(parts)-4],
+		ComponentId:    parts[len(parts)-3],
+		DrawableId:     parts[len(parts)-2],
+		TextureId:      parts[len(parts)-1],
+	}
+	return file, file.valid()
+}
+
+// clothingFileFromQuery reads the catalog identity from explicit query
+// parameters, falling back to the file name convention for missing ones.
+func clothingFileFromQuery(query url.Values, fileName string) (ClothingFile, bool) {
+	file, _ := parseClothingFileName(fileName)
+	if v := query.Get("collection"); v != "" {
+		file.CollectionName = v
+	}
+	if v := query.Get("gender"); v != "" {
+		file.Gender = v
+	}
+	if v := query.Get("componentType"); v != "" {
+		file.ComponentType = v
+	}
+	if v := query.Get("componentId"); v != "" {
+		file.ComponentId = v
+	}
+	if v := query.Get("drawableId"); v != "" {
+		file.DrawableId = v
+	}
+	if v := query.Get("textureId"); v != "" {
+		file.TextureId = v
+	}
+	return file, file.valid()
+}
+
+// SaveClothingItem upserts a texture by its catalog hash. Nil presets or a
+// nil perceptual hash keep the stored ones.
+func (c *UploadController) SaveClothingItem(ctx context.Context, file ClothingFile, fileName string, size int64, mime string, presets []string, perceptual *utils.PerceptualHash) error {
+	gender := 1
+	if file.Gender == "0" {
+		gender = 0
+	}
+	var presetNames any
+	if presets != nil {
+		presetNames = strings.Join(presets, ",")
+	}
+	var phash, dhash any
+	if perceptual != nil {
+		phash, dhash = perceptual.PHash, perceptual.DHash
+	}
+	_, err := c.DB.ExecContext(ctx, `INSERT INTO clothing_items
+		(collection, gender, component_type, component_id, drawable_id, texture_id, hash, file_name, size, mime, presets, phash, dhash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, ''), ?, ?)
+		ON DUPLICATE KEY UPDATE file_name = VALUES(file_name), size = VALUES(size), mime = VALUES(mime), presets = COALESCE(?, presets),
+			phash = COALESCE(VALUES(phash), phash), dhash = COALESCE(VALUES(dhash), dhash)`,
+		file.CollectionName, gender, file.ComponentType, mustParseInt(file.ComponentId), mustParseInt(file.DrawableId), mustParseInt(file.TextureId),
+		file.Hash(), fileName, size, mime, presetNames, phash, dhash, presetNames,
+	)
+	return err
+}
+
+// perceptualEntry is a catalog texture with its perceptual hashes.
+type perceptualEntry struct {
+	Hash       string
+	Collection string
+	FileName   string
+	utils.PerceptualHash
+}
+
+// GetPerceptualHashes loads every texture that has perceptual hashes. Pass
+// "null" as collection to load every collection.
+func (c *UploadController) GetPerceptualHashes(ctx context.Context, collection string) ([]perceptualEntry, error) {
+	query := "SELECT hash, collection, file_name, phash, dhash FROM clothing_items WHERE phash IS NOT NULL AND dhash IS NOT NULL"
+	args := []any{}
+	if collection != "null" {
+		query += " AND collection = ?"
+		args = append(args, collection)
+	}
+	query += " ORDER BY id"
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []perceptualEntry{}
+	for rows.Next() {
+		var entry perceptualEntry
+		if err := rows.Scan(&entry.Hash, &entry.Collection, &entry.FileName, &entry.PHash, &entry.DHash); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (c *UploadController) DeleteClothingItemByFileName(ctx context.Context, fileName string) error {
+	_, err := c.DB.ExecContext(ctx, "DELETE FROM clothing_items WHERE file_name = ?", fileName)
+	return err
+}
+
+const clothingItemQuery = `SELECT ci.collection, ci.gender, ci.component_type, ci.component_id, ci.drawable_id, ci.texture_id,
+	ci.size, ci.hash, ci.file_name, ci.presets, COALESCE(tp.price, 0)
+	FROM clothing_items ci LEFT JOIN texture_prices tp ON tp.hash = ci.hash`
+
+func (c *UploadController) scanClothingItem(row interface{ Scan(...any) error }) (*ClothingItem, error) {
+	var item ClothingItem
+	var presets string
+	err := row.Scan(&item.CollectionName, &item.Gender, &item.ComponentType, &item.ComponentId, &item.DrawableId, &item.TextureId,
+		&item.Size, &item.Hash, &item.FileName, &presets, &item.Price)
+	if err != nil {
+		return nil, err
+	}
+	if presets != "" {
+		item.PresetNames = strings.Split(presets, ",")
+	}
+	item.Presets = c.presetUrls(item.CollectionName, item.FileName, item.PresetNames)
+	return &item, nil
+}
+
+// GetClothingItems loads the catalog joined with texture prices. Pass
+// "null" as collection to load every collection.
+func (c *UploadController) GetClothingItems(ctx context.Context, collection string) ([]*ClothingItem, error) {
+	query := clothingItemQuery
+	args := []any{}
+	if collection != "null" {
+		query += " WHERE ci.collection = ?"
+		args = append(args, collection)
+	}
+	query += " ORDER BY ci.id"
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	response := []*ClothingItem{}
+	for rows.Next() {
+		item, err := c.scanClothingItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		response = append(response, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GetClothingItem loads a single texture by its catalog hash, it returns
+// sql.ErrNoRows when there is none.
+func (c *UploadController) GetClothingItem(ctx context.Context, hash string) (*ClothingItem, error) {
+	return c.scanClothingItem(c.DB.QueryRowContext(ctx, clothingItemQuery+" WHERE ci.hash = ?", hash))
+}
+
+// perceptualHashOf decodes a stored image and fingerprints it.
+func (c *UploadController) perceptualHashOf(ctx context.Context, name string) (*utils.PerceptualHash, error) {
+	obj, err := c.Storage.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	img, _, err := image.Decode(obj)
+	if err != nil {
+		return nil, err
+	}
+	hash := utils.ComputePerceptualHash(img)
+	return &hash, nil
+}
+
+// BackfillClothing scans the storage root and records every texture that
+// follows the file name convention. It is safe to run more than once, the
+// preset names of textures already recorded are kept.
+func (c *UploadController) BackfillClothing(ctx context.Context) (int, error) {
+	files, err := c.Storage.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, file := range files {
+		if strings.ToLower(filepath.Ext(file.Name)) != ".webp" {
+			continue
+		}
+		clothingFile, ok := parseClothingFileName(file.Name)
+		if !ok {
+			fmt.Printf("Skipping %s: name does not follow the clothing convention\n", file.Name)
+			continue
+		}
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(".webp")
+		}
+		perceptual, err := c.perceptualHashOf(ctx, file.Name)
+		if err != nil {
+			fmt.Printf("Skipping the perceptual hash of %s: %s\n", file.Name, err)
+		}
+		if err := c.SaveClothingItem(ctx, clothingFile, file.Name, file.Size, contentType, nil, perceptual); err != nil {
+			return count, fmt.Errorf("saving %s: %w", file.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"lorraxs/fivem_cdn_server/utils"
+	"net/http"
+	"strconv"
+)
+
+const defaultDuplicateDistance = 6
+
+type DuplicateItem struct {
+	Hash       string `json:"hash"`
+	Collection string `json:"collection"`
+	FileName   string `json:"fileName"`
+	Url        string `json:"url"`
+	// Distance to the first item of the cluster.
+	Distance int `json:"distance"`
+}
+
+type DuplicateCluster struct {
+	Items []DuplicateItem `json:"items"`
+}
+
+type DuplicatesResponse struct {
+	Success   bool               `json:"success"`
+	Algorithm string             `json:"algorithm"`
+	Distance  int                `json:"distance"`
+	Clusters  []DuplicateCluster `json:"clusters"`
+}
+
+// GetDuplicates lists clusters of textures whose perceptual hashes are at
+// most distance bits apart. Query parameters: distance (default 6), algo
+// (phash or dhash) and collection.
+func (c *UploadController) GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	distance := defaultDuplicateDistance
+	if v := r.URL.Query().Get("distance"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 64 {
+			http.Error(w, "Invalid distance parameter", http.StatusBadRequest)
+			return
+		}
+		distance = n
+	}
+	algorithm := r.URL.Query().Get("algo")
+	if algorithm == "" {
+		algorithm = "phash"
+	}
+	if algorithm != "phash" && algorithm != "dhash" {
+		http.Error(w, "Invalid algo parameter", http.StatusBadRequest)
+		return
+	}
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		collection = "null"
+	}
+
+	entries, err := c.GetPerceptualHashes(r.Context(), collection)
+	if err != nil {
+		errStr := fmt.Sprintf("Error loading perceptual hashes. Reason: %s\n", err)
+		fmt.Println(errStr)
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]uint64, len(entries))
+	for i, entry := range entries {
+		if algorithm == "dhash" {
+			hashes[i] = entry.DHash
+		} else {
+			hashes[i] = entry.PHash
+		}
+	}
+
+	response := DuplicatesResponse{Success: true, Algorithm: algorithm, Distance: distance, Clusters: []DuplicateCluster{}}
+	for _, members := range clusterHashes(hashes, distance) {
+		cluster := DuplicateCluster{}
+		for _, i := range members {
+			entry := entries[i]
+			cluster.Items = append(cluster.Items, DuplicateItem{
+				Hash:       entry.Hash,
+				Collection: entry.Collection,
+				FileName:   entry.FileName,
+				Url:        c.staticUrl(entry.Collection, "static", entry.FileName),
+				Distance:   utils.HammingDistance(hashes[members[0]], hashes[i]),
+			})
+		}
+		response.Clusters = append(response.Clusters, cluster)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clusterHashes links every pair within distance and returns the connected
+// groups with more than one member. Below 8 bits two close hashes must share
+// at least one identical byte, so only hashes sharing a byte are compared.
+func clusterHashes(hashes []uint64, distance int) [][]int {
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	link := func(a, b int) {
+		if utils.HammingDistance(hashes[a], hashes[b]) <= distance {
+			if ra, rb := find(a), find(b); ra != rb {
+				parent[rb] = ra
+			}
+		}
+	}
+
+	if distance < 8 {
+		for band := 0; band < 8; band++ {
+			buckets := map[uint8][]int{}
+			for i, hash := range hashes {
+				key := uint8(hash >> (band * 8))
+				buckets[key] = append(buckets[key], i)
+			}
+			for _, bucket := range buckets {
+				for a := 0; a < len(bucket); a++ {
+					for b := a + 1; b < len(bucket); b++ {
+						link(bucket[a], bucket[b])
+					}
+				}
+			}
+		}
+	} else {
+		for a := range hashes {
+			for b := a + 1; b < len(hashes); b++ {
+				link(a, b)
+			}
+		}
+	}
+
+	groups := map[int][]int{}
+	order := []int{}
+	for i := range hashes {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			order = append(order, root)
+		}
+		groups[root] = append(groups[root], i)
+	}
+	clusters := [][]int{}
+	for _, root := range order {
+		if len(groups[root]) > 1 {
+			clusters = append(clusters, groups[root])
+		}
+	}
+	return clusters
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"lorraxs/fivem_cdn_server/utils"
+	"lorraxs/fivem_cdn_server/webhooks"
+	"net/http"
+	"strconv"
+)
+
+type TextureEvent struct {
+	FileName   string            `json:"fileName"`
+	Url        string            `json:"url"`
+	Hash       string            `json:"hash,omitempty"`
+	Collection string            `json:"collection,omitempty"`
+	Presets    map[string]string `json:"presets,omitempty"`
+}
+
+type CollectionEvent struct {
+	Collection string `json:"collection"`
+	Files      int    `json:"files"`
+}
+
+type PriceEvent struct {
+	Hash  string  `json:"hash"`
+	Price float64 `json:"price"`
+}
+
+type CacheFlushedEvent struct {
+	Items int `json:"items"`
+}
+
+// emit publishes a catalog event to the /events stream and the webhooks.
+// Failing to record it is logged but never fails the change that caused it.
+func (c *UploadController) emit(eventType string, data any) {
+	if c.Events != nil {
+		if err := c.Events.Publish(eventType, data); err != nil {
+			fmt.Printf("Error streaming %s: %s\n", eventType, err)
+		}
+	}
+	if c.Webhooks != nil {
+		if err := c.Webhooks.Emit(c.ctx, eventType, data); err != nil {
+			fmt.Printf("Error emitting %s: %s\n", eventType, err)
+		}
+	}
+}
+
+func (c *UploadController) textureEvent(stored *StoredFile) TextureEvent {
+	event := TextureEvent{
+		FileName:   stored.Name,
+		Url:        utils.JoinURL(c.Config.App.BaseUrl, "static", stored.Name),
+		Collection: stored.collection(),
+		Presets:    c.presetUrls(stored.collection(), stored.Name, stored.Presets),
+	}
+	if stored.Clothing != nil {
+		event.Hash = stored.Clothing.Hash()
+	}
+	return event
+}
+
+// GetWebhookDeliveries returns the webhook delivery log, newest first.
+// Query parameters: event, status, limit and before (a delivery id).
+func (c *UploadController) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if c.Webhooks == nil {
+		http.Error(w, "Webhooks are not enabled", http.StatusNotFound)
+		return
+	}
+	filter := webhooks.DeliveryFilter{
+		EventType: r.URL.Query().Get("event"),
+		Status:    r.URL.Query().Get("status"),
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if v := r.URL.Query().Get("before"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+		filter.BeforeID = n
+	}
+	deliveries, err := c.Webhooks.Deliveries(r.Context(), filter)
+	if err != nil {
+		errStr := fmt.Sprintf("Error loading webhook deliveries. Reason: %s\n", err)
+		fmt.Println(errStr)
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries)
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// variantCache keeps track of the rendered variants on disk and evicts the
+// least recently served ones once they take more than maxSize bytes.
+type variantCache struct {
+	maxSize int64
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type variantFile struct {
+	path string
+	size int64
+}
+
+// newVariantCache picks up the variants already under dir, the oldest are
+// evicted first.
+func newVariantCache(dir string, maxSize int64) *variantCache {
+	cache := &variantCache{maxSize: maxSize, lru: list.New(), entries: map[string]*list.Element{}}
+	type found struct {
+		variantFile
+		modTime time.Time
+	}
+	files := []found{}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, found{variantFile{path, info.Size()}, info.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		cache.add(f.path, f.size)
+	}
+	return cache
+}
+
+// touch marks path as just served.
+func (c *variantCache) touch(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[path]; ok {
+		c.lru.MoveToFront(element)
+	}
+}
+
+// add records a variant written to path and evicts what no longer fits.
+func (c *variantCache) add(path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[path]; ok {
+		file := element.Value.(*variantFile)
+		c.size += size - file.size
+		file.size = size
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[path] = c.lru.PushFront(&variantFile{path, size})
+		c.size += size
+	}
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		oldest := c.lru.Back()
+		file := oldest.Value.(*variantFile)
+		c.lru.Remove(oldest)
+		delete(c.entries, file.path)
+		c.size -= file.size
+		// a request that opened the file before keeps reading it
+		os.Remove(file.path)
+	}
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"lorraxs/fivem_cdn_server/storage"
+	"lorraxs/fivem_cdn_server/utils"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type variantParams struct {
+	Resize  utils.ResizeOptions
+	Quality int
+	Format  utils.Format
+}
+
+func parseDimension(query url.Values, names ...string) (int, error) {
+	for _, name := range names {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid %s parameter", name)
+			}
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// quantize rounds n up to a multiple of step, without going past limit.
+func quantize(n, step, limit int) int {
+	if n <= 0 {
+		return n
+	}
+	return min((n+step-1)/step*step, limit)
+}
+
+// parseVariantParams reads width/height/fit/quality/format from the query
+// and negotiates the output format against the Accept header. Sizes are
+// rounded up to the configured size step and qualities to the nearest
+// quality step, so a file only ever has a bounded set of variants.
+// transform is false when the stored file can be sent as-is.
+func (c *UploadController) parseVariantParams(r *http.Request, source utils.Format) (params variantParams, transform bool, err error) {
+	query := r.URL.Query()
+	maxDimension := c.Config.Images.MaxDimension
+	if params.Resize.Width, err = parseDimension(query, "w", "width"); err != nil {
+		return
+	}
+	if params.Resize.Height, err = parseDimension(query, "h", "height"); err != nil {
+		return
+	}
+	if params.Resize.Width > maxDimension || params.Resize.Height > maxDimension {
+		err = fmt.Errorf("width and height must not exceed %d", maxDimension)
+		return
+	}
+	params.Resize.Width = quantize(params.Resize.Width, c.Config.Images.SizeStep, maxDimension)
+	params.Resize.Height = quantize(params.Resize.Height, c.Config.Images.SizeStep, maxDimension)
+	params.Resize.Fit = strings.ToLower(query.Get("fit"))
+	switch params.Resize.Fit {
+	case "", utils.FitContain, utils.FitCover, utils.FitFill:
+	default:
+		err = errors.New("invalid fit parameter")
+		return
+	}
+	params.Quality = c.Config.Images.DefaultQuality
+	qualitySet := false
+	v := query.Get("q")
+	if v == "" {
+		v = query.Get("quality")
+	}
+	if v != "" {
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil || n < 1 || n > 100 {
+			err = errors.New("invalid quality parameter")
+			return
+		}
+		step := c.Config.Images.QualityStep
+		params.Quality, qualitySet = min(max((n+step/2)/step*step, step), 100), true
+	}
+	if v := query.Get("format"); v != "" {
+		format, ok := utils.ParseFormat(v)
+		if !ok || !utils.HasEncoder(format) {
+			err = errors.New("unsupported format parameter")
+			return
+		}
+		params.Format = format
+	} else {
+		format, ok := utils.NegotiateFormat(r.Header.Get("Accept"), source)
+		if !ok {
+			format = source
+		}
+		params.Format = format
+	}
+	transform = params.Resize.Width > 0 || params.Resize.Height > 0 || qualitySet || params.Format != source
+	return
+}
+
+func (p variantParams) key(info storage.ObjectInfo) string {
+	source := info.ETag
+	if source == "" {
+		source = fmt.Sprintf("%s:%d:%d", info.Name, info.Size, info.ModTime.UnixNano())
+	}
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s|%d|%d|%s|%d|%s", source, p.Resize.Width, p.Resize.Height, p.Resize.Fit, p.Quality, p.Format)
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// cacheControl returns the Cache-Control policy of a static response.
+// Hashed urls are immutable, named files get the shorter configured max-age
+// and protected collections are private and never outlive their signature.
+func (c *UploadController) cacheControl(r *http.Request, collection string, hashed bool) string {
+	maxAge := c.Config.Cache.MaxAge
+	if hashed {
+		maxAge = c.Config.Cache.HashedMaxAge
+	}
+	if c.requiresSignature(collection) {
+		if expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64); err == nil {
+			maxAge = min(maxAge, time.Until(time.Unix(expires, 0)))
+		}
+		return fmt.Sprintf("private, max-age=%d", max(int64(maxAge/time.Second), 0))
+	}
+	if hashed {
+		return fmt.Sprintf("public, max-age=%d, immutable", int64(maxAge/time.Second))
+	}
+	return fmt.Sprintf("public, max-age=%d", int64(maxAge/time.Second))
+}
+
+// serveImage sends a stored image, resizing and transcoding it on the fly
+// when the request asks for it. Derived variants are cached on local disk,
+// within the configured size.
+func (c *UploadController) serveImage(w http.ResponseWriter, r *http.Request, fileName string, cacheControl string) {
+	w.Header().Add("Vary", "Accept")
+	source, ok := utils.ParseFormat(filepath.Ext(fileName))
+	if !ok {
+		storage.Serve(w, r, c.Storage, fileName, cacheControl)
+		return
+	}
+	params, transform, err := c.parseVariantParams(r, source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !transform {
+		storage.Serve(w, r, c.Storage, fileName, cacheControl)
+		return
+	}
+
+	info, err := c.Storage.Stat(r.Context(), fileName)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := params.key(info)
+	cachePath := filepath.Join(c.Config.Images.CachePath, key[:2], key+params.Format.Ext())
+	f, err := os.Open(cachePath)
+	if err == nil {
+		c.variants.touch(cachePath)
+	} else {
+		size, err := c.renderVariant(r, fileName, params, cachePath)
+		if err != nil {
+			errStr := fmt.Sprintf("Error rendering the image. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		f, err = os.Open(cachePath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c.variants.add(cachePath, size)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", params.Format.ContentType())
+	// the key already covers the source content hash and the params
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// renderVariant writes the variant to cachePath and returns its size.
+func (c *UploadController) renderVariant(r *http.Request, fileName string, params variantParams, cachePath string) (int64, error) {
+	src, err := c.Storage.Get(r.Context(), fileName)
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(src)
+	src.Close()
+	if err != nil {
+		return 0, err
+	}
+	img = utils.Resize(img, params.Resize)
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".variant-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := utils.EncodeImage(tmp, img, params.Format, params.Quality); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	// rename is atomic, concurrent requests for the same variant at worst
+	// render it twice
+	return info.Size(), os.Rename(tmp.Name(), cachePath)
+}
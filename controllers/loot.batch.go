@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lorraxs/fivem_cdn_server/auth"
+	"lorraxs/fivem_cdn_server/loot"
+	"net/http"
+)
+
+// maxBatchRolls caps the total count of a batch roll.
+const maxBatchRolls = 100
+
+type BatchRollSpec struct {
+	ComponentType string `json:"componentType"`
+	ComponentId   int    `json:"componentId"`
+	// Rate is the percent chance that a roll drops anything, 100 when
+	// omitted.
+	Rate  *float64 `json:"rate,omitempty"`
+	Count int      `json:"count"`
+}
+
+type BatchRollRequest struct {
+	Table  string          `json:"table"`
+	Player string          `json:"player"`
+	Seed   *uint64         `json:"seed,omitempty"`
+	Rolls  []BatchRollSpec `json:"rolls"`
+	// Unique keeps an item from dropping twice in the batch.
+	Unique bool `json:"unique"`
+	// Owned are the hashes of items the player already owns, they never
+	// drop.
+	Owned []string `json:"owned"`
+}
+
+type BatchRollResult struct {
+	ComponentType string `json:"componentType"`
+	ComponentId   int    `json:"componentId"`
+	Success       bool   `json:"success"`
+	Data          any    `json:"data"`
+	Tier          string `json:"tier,omitempty"`
+	PityHit       bool   `json:"pityHit,omitempty"`
+}
+
+type BatchRollResponse struct {
+	Success bool              `json:"success"`
+	Table   string            `json:"table"`
+	Results []BatchRollResult `json:"results"`
+	// Pity is the state of the player after the whole batch, chances are
+	// for the component of the last spec.
+	Pity []loot.PityState `json:"pity,omitempty"`
+}
+
+func (req *BatchRollRequest) validate(allowSeed bool) error {
+	if len(req.Player) > 64 {
+		return errors.New("player must be at most 64 characters")
+	}
+	if req.Seed != nil && !allowSeed {
+		return errors.New("seeded rolls are disabled")
+	}
+	if len(req.Rolls) == 0 {
+		return errors.New("rolls must not be empty")
+	}
+	total := 0
+	for i := range req.Rolls {
+		spec := &req.Rolls[i]
+		if spec.ComponentType == "" {
+			return fmt.Errorf("roll %d: componentType is required", i)
+		}
+		if spec.Count == 0 {
+			spec.Count = 1
+		}
+		if spec.Count < 0 {
+			return fmt.Errorf("roll %d: invalid count", i)
+		}
+		total += spec.Count
+	}
+	if total > maxBatchRolls {
+		return fmt.Errorf("at most %d rolls per batch", maxBatchRolls)
+	}
+	return nil
+}
+
+// PostRandomClothingBatch rolls several specs in one request, one result
+// per roll in the order of the specs. The body is a BatchRollRequest. With
+// a player every roll counts towards their pity, whether something drops or
+// not, the batch is recorded all or nothing and a key with the loot:roll
+// scope is required.
+func (c *UploadController) PostRandomClothingBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRollRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "Invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(c.Config.Loot.AllowSeed); err != nil {
+		http.Error(w, "Invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Player != "" {
+		if _, ok := c.Auth.Authorize(w, r, auth.ScopeLootRoll); !ok {
+			return
+		}
+	}
+	table, ok := c.loadLootTable(w, r, req.Table)
+	if !ok {
+		return
+	}
+
+	var session *loot.Session
+	if req.Player != "" {
+		var err error
+		session, err = c.Loot.Begin(r.Context(), table.Name, req.Player)
+		if err != nil {
+			errStr := fmt.Sprintf("Error loading the pity counters. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		defer session.Rollback()
+	}
+
+	exclude := make(map[string]bool, len(req.Owned))
+	for _, hash := range req.Owned {
+		exclude[hash] = true
+	}
+	rng := loot.NewRand(req.Seed)
+	response := BatchRollResponse{Success: true, Table: table.Name, Results: []BatchRollResult{}}
+	for _, spec := range req.Rolls {
+		rate := 100.0
+		if spec.Rate != nil {
+			rate = *spec.Rate
+		}
+		pool, items := c.lootPool(table, spec.ComponentType, spec.ComponentId, exclude)
+		for n := 0; n < spec.Count; n++ {
+			result := BatchRollResult{ComponentType: spec.ComponentType, ComponentId: spec.ComponentId, Data: "Rate not met"}
+			var rolled loot.Item
+			found := false
+			if session != nil {
+				roll, ok, err := session.Roll(r.Context(), pool, rng, rate)
+				if err != nil {
+					errStr := fmt.Sprintf("Error rolling for the player. Reason: %s\n", err)
+					fmt.Println(errStr)
+					http.Error(w, errStr, http.StatusInternalServerError)
+					return
+				}
+				if ok && roll.Missed {
+					response.Results = append(response.Results, result)
+					continue
+				}
+				if ok {
+					rolled, result.Tier, result.PityHit, found = roll.Item, roll.Tier, roll.Pity, true
+				}
+			} else {
+				if !loot.Hit(rng, rate) {
+					response.Results = append(response.Results, result)
+					continue
+				}
+				rolled, result.Tier, found = pool.Roll(rng)
+			}
+			if !found {
+				result.Data = "Item not found"
+				response.Results = append(response.Results, result)
+				continue
+			}
+			result.Success = true
+			result.Data = c.withSignedUrl(items[rolled.Hash])
+			response.Results = append(response.Results, result)
+			if req.Unique {
+				exclude[rolled.Hash] = true
+				pool, items = c.lootPool(table, spec.ComponentType, spec.ComponentId, exclude)
+			}
+		}
+	}
+
+	if session != nil {
+		last := req.Rolls[len(req.Rolls)-1]
+		pool, _ := c.lootPool(table, last.ComponentType, last.ComponentId, exclude)
+		response.Pity = pool.States(session.Pity())
+		if err := session.Commit(); err != nil {
+			errStr := fmt.Sprintf("Error recording the rolls. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
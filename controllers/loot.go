@@ -0,0 +1,379 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lorraxs/fivem_cdn_server/auth"
+	"lorraxs/fivem_cdn_server/loot"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type RandomClothingResponse struct {
+	Success bool   `json:"success"`
+	Data    any    `json:"data"`
+	Table   string `json:"table,omitempty"`
+	Tier    string `json:"tier,omitempty"`
+	// PityHit and Pity are only set for rolls made for a player.
+	PityHit bool             `json:"pityHit,omitempty"`
+	Pity    []loot.PityState `json:"pity,omitempty"`
+}
+
+// lootTable loads the named table, or the configured default when name is
+// empty. The default table falls back to the built-in one when it is not
+// stored, any other name has to exist.
+func (c *UploadController) lootTable(ctx context.Context, name string) (*loot.Table, error) {
+	if name == "" {
+		name = c.Config.Loot.DefaultTable
+	}
+	table, err := c.Loot.Get(ctx, name)
+	if errors.Is(err, loot.ErrNotFound) && name == c.Config.Loot.DefaultTable {
+		return loot.DefaultTable(name), nil
+	}
+	return table, err
+}
+
+// lootPool applies table to the catalog items of a component, leaving out
+// the hashes in exclude.
+func (c *UploadController) lootPool(table *loot.Table, componentType string, componentId int, exclude map[string]bool) (*loot.Pool, map[string]*ClothingItem) {
+	byHash := map[string]*ClothingItem{}
+	items := []loot.Item{}
+	for _, item := range c.Catalog.Snapshot().ByComponent(componentType, componentId) {
+		if exclude[item.Hash] {
+			continue
+		}
+		byHash[item.Hash] = item
+		items = append(items, loot.Item{Hash: item.Hash, Price: item.Price})
+	}
+	return loot.NewPool(table, items), byHash
+}
+
+// seedFromQuery reads the seed parameter, which is only honoured when
+// [loot] allowSeed is set.
+func (c *UploadController) seedFromQuery(r *http.Request) (*uint64, error) {
+	v := r.URL.Query().Get("seed")
+	if v == "" {
+		return nil, nil
+	}
+	if !c.Config.Loot.AllowSeed {
+		return nil, errors.New("seeded rolls are disabled")
+	}
+	seed, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid seed parameter")
+	}
+	return &seed, nil
+}
+
+// randomQuery holds the parameters shared by the random endpoints.
+type randomQuery struct {
+	componentType string
+	componentId   int
+	table         string
+	rate          float64
+	player        string
+	seed          *uint64
+}
+
+func (c *UploadController) randomQueryFrom(r *http.Request) (*randomQuery, error) {
+	query := r.URL.Query()
+	q := &randomQuery{
+		componentType: query.Get("componentType"),
+		table:         query.Get("table"),
+		player:        query.Get("player"),
+	}
+	componentId := query.Get("componentId")
+	rate := query.Get("rate")
+	if q.componentType == "" || componentId == "" || rate == "" {
+		return nil, errors.New("Missing required parameters")
+	}
+	var err error
+	q.componentId, err = strconv.Atoi(componentId)
+	if err != nil {
+		return nil, errors.New("Invalid componentId parameter")
+	}
+	q.rate, err = strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return nil, errors.New("Invalid rate parameter")
+	}
+	if len(q.player) > 64 {
+		return nil, errors.New("Invalid player parameter")
+	}
+	q.seed, err = c.seedFromQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// loadLootTable resolves the named table, writing the error response when
+// it can not.
+func (c *UploadController) loadLootTable(w http.ResponseWriter, r *http.Request, name string) (*loot.Table, bool) {
+	table, err := c.lootTable(r.Context(), name)
+	if errors.Is(err, loot.ErrNotFound) {
+		http.Error(w, "Loot table not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Error loading the loot table. Reason: %s\n", err)
+		fmt.Println(errStr)
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return nil, false
+	}
+	return table, true
+}
+
+// GetRandomClothing rolls an item of a component from a loot table.
+// Query parameters: componentType, componentId and rate (the percent
+// chance that anything drops at all) are required, table (the configured
+// default when empty), seed and player are optional. With a player
+// identifier the roll counts towards their pity, whether something drops
+// or not, and the response carries their pity state. Rolling for a player takes a key with
+// the loot:roll scope.
+func (c *UploadController) GetRandomClothing(w http.ResponseWriter, r *http.Request) {
+	q, err := c.randomQueryFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.player != "" {
+		if _, ok := c.Auth.Authorize(w, r, auth.ScopeLootRoll); !ok {
+			return
+		}
+	}
+	table, ok := c.loadLootTable(w, r, q.table)
+	if !ok {
+		return
+	}
+	rng := loot.NewRand(q.seed)
+	response := RandomClothingResponse{Table: table.Name, Data: "Rate not met"}
+	pool, items := c.lootPool(table, q.componentType, q.componentId, nil)
+	switch {
+	case q.player != "":
+		result, ok, err := c.Loot.RollFor(r.Context(), pool, q.player, rng, q.rate)
+		if err != nil {
+			errStr := fmt.Sprintf("Error rolling for the player. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		response.Pity = result.State
+		if result.Missed {
+			break
+		}
+		response.Success = true
+		response.Data = c.withSignedUrl(items[result.Item.Hash])
+		response.Tier = result.Tier
+		response.PityHit = result.Pity
+	case !loot.Hit(rng, q.rate):
+	default:
+		rolled, tier, ok := pool.Roll(rng)
+		if !ok {
+			http.Error(w, "Item not found", http.StatusNotFound)
+			return
+		}
+		response.Success = true
+		response.Data = c.withSignedUrl(items[rolled.Hash])
+		response.Tier = tier
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// maxSimulatedRolls caps the simulate parameter of the odds endpoint, which
+// anyone can call.
+const maxSimulatedRolls = 10000
+
+type ItemOdds struct {
+	Item   *ClothingItem `json:"item"`
+	Tier   string        `json:"tier"`
+	Weight float64       `json:"weight"`
+	Chance float64       `json:"chance"`
+	// Observed is the share of simulated rolls that dropped the item.
+	Observed *float64 `json:"observed,omitempty"`
+}
+
+type TierOdds struct {
+	loot.TierOdds
+	Observed *float64 `json:"observed,omitempty"`
+}
+
+type OddsSimulation struct {
+	Rolls   int     `json:"rolls"`
+	Nothing float64 `json:"nothing"`
+	// MaxDeviation is the largest difference between an observed and an
+	// exact chance, over the nothing outcome, tiers and items.
+	MaxDeviation float64 `json:"maxDeviation"`
+}
+
+type RandomOddsResponse struct {
+	Success       bool             `json:"success"`
+	Table         string           `json:"table"`
+	ComponentType string           `json:"componentType"`
+	ComponentId   int              `json:"componentId"`
+	Rate          float64          `json:"rate"`
+	Nothing       float64          `json:"nothing"`
+	Tiers         []TierOdds       `json:"tiers"`
+	Items         []ItemOdds       `json:"items"`
+	Pity          []loot.PityState `json:"pity,omitempty"`
+	Simulation    *OddsSimulation  `json:"simulation,omitempty"`
+}
+
+// GetRandomClothingOdds publishes the exact drop chances of the random
+// endpoint for the same parameters, computed by the code that rolls. With a
+// player the chances are those of their next roll given their pity, which
+// like rolling for them takes a key with the loot:roll scope.
+// simulate=<n> also rolls n times, without touching any pity counter, and
+// reports the observed shares next to the exact ones.
+func (c *UploadController) GetRandomClothingOdds(w http.ResponseWriter, r *http.Request) {
+	q, err := c.randomQueryFrom(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	simulate := 0
+	if v := r.URL.Query().Get("simulate"); v != "" {
+		simulate, err = strconv.Atoi(v)
+		if err != nil || simulate < 0 || simulate > maxSimulatedRolls {
+			http.Error(w, fmt.Sprintf("Invalid simulate parameter, at most %d rolls", maxSimulatedRolls), http.StatusBadRequest)
+			return
+		}
+	}
+	if q.player != "" {
+		if _, ok := c.Auth.Authorize(w, r, auth.ScopeLootRoll); !ok {
+			return
+		}
+	}
+	table, ok := c.loadLootTable(w, r, q.table)
+	if !ok {
+		return
+	}
+
+	pool, items := c.lootPool(table, q.componentType, q.componentId, nil)
+	pity := loot.Pity{}
+	if q.player != "" {
+		pity, err = c.Loot.Pity(r.Context(), q.player, table.Name)
+		if err != nil {
+			errStr := fmt.Sprintf("Error loading the pity counters. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+	}
+	odds := pool.ExactOdds(q.rate, pity)
+
+	response := RandomOddsResponse{
+		Success:       true,
+		Table:         table.Name,
+		ComponentType: q.componentType,
+		ComponentId:   q.componentId,
+		Rate:          q.rate,
+		Nothing:       odds.Nothing,
+		Tiers:         []TierOdds{},
+		Items:         []ItemOdds{},
+	}
+	if q.player != "" {
+		response.Pity = pool.States(pity)
+	}
+	for _, tier := range odds.Tiers {
+		response.Tiers = append(response.Tiers, TierOdds{TierOdds: tier})
+	}
+	for _, item := range odds.Items {
+		response.Items = append(response.Items, ItemOdds{
+			Item:   c.withSignedUrl(items[item.Item.Hash]),
+			Tier:   item.Tier,
+			Weight: item.Weight,
+			Chance: item.Chance,
+		})
+	}
+
+	if simulate > 0 {
+		sim := pool.Simulate(loot.NewRand(q.seed), q.rate, pity, simulate)
+		share := func(count int) *float64 {
+			v := float64(count) / float64(sim.Rolls)
+			return &v
+		}
+		summary := &OddsSimulation{Rolls: sim.Rolls, Nothing: *share(sim.Nothing)}
+		summary.MaxDeviation = math.Abs(summary.Nothing - response.Nothing)
+		for i := range response.Tiers {
+			response.Tiers[i].Observed = share(sim.Tiers[response.Tiers[i].Tier])
+			summary.MaxDeviation = max(summary.MaxDeviation, math.Abs(*response.Tiers[i].Observed-response.Tiers[i].Chance))
+		}
+		for i := range response.Items {
+			response.Items[i].Observed = share(sim.Items[odds.Items[i].Item.Hash])
+			summary.MaxDeviation = max(summary.MaxDeviation, math.Abs(*response.Items[i].Observed-response.Items[i].Chance))
+		}
+		response.Simulation = summary
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *UploadController) GetLootTables(w http.ResponseWriter, r *http.Request) {
+	tables, err := c.Loot.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tables)
+}
+
+func (c *UploadController) GetLootTable(w http.ResponseWriter, r *http.Request) {
+	table, err := c.Loot.Get(r.Context(), mux.Vars(r)["name"])
+	if errors.Is(err, loot.ErrNotFound) {
+		http.Error(w, "Loot table not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(table)
+}
+
+// PutLootTable creates or replaces a loot table from a JSON body shaped
+// like loot.Table, the name comes from the url.
+func (c *UploadController) PutLootTable(w http.ResponseWriter, r *http.Request) {
+	var table loot.Table
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&table); err != nil {
+		http.Error(w, "Invalid loot table: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	table.Name = mux.Vars(r)["name"]
+	if err := table.Validate(); err != nil {
+		http.Error(w, "Invalid loot table: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := c.Loot.Save(r.Context(), &table); err != nil {
+		errStr := fmt.Sprintf("Error saving the loot table. Reason: %s\n", err)
+		fmt.Println(errStr)
+		http.Error(w, errStr, http.StatusInternalServerError)
+		return
+	}
+	c.GetLootTable(w, r)
+}
+
+func (c *UploadController) DeleteLootTable(w http.ResponseWriter, r *http.Request) {
+	err := c.Loot.Delete(r.Context(), mux.Vars(r)["name"])
+	if errors.Is(err, loot.ErrNotFound) {
+		http.Error(w, "Loot table not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
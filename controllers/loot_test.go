@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"lorraxs/fivem_cdn_server/config"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRandomQueryFrom(t *testing.T) {
+	c := &UploadController{Config: &config.Config{}}
+	for _, test := range []struct {
+		query string
+		err   string
+		rate  float64
+	}{
+		{"componentType=jbib&componentId=11&rate=35.5", "", 35.5},
+		{"componentType=jbib&componentId=11&rate=0", "", 0},
+		{"componentType=jbib&componentId=11", "Missing required parameters", 0},
+		{"componentType=jbib&rate=100", "Missing required parameters", 0},
+		{"componentType=jbib&componentId=11&rate=often", "Invalid rate parameter", 0},
+		{"componentType=jbib&componentId=x&rate=100", "Invalid componentId parameter", 0},
+		{"componentType=jbib&componentId=11&rate=100&seed=4", "seeded rolls are disabled", 0},
+	} {
+		q, err := c.randomQueryFrom(httptest.NewRequest("GET", "/upload/clothing/random?"+test.query, nil))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %s", test.query, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err)
+			continue
+		}
+		if q.componentType != "jbib" || q.componentId != 11 || q.rate != test.rate {
+			t.Errorf("%s: parsed %+v", test.query, q)
+		}
+	}
+}
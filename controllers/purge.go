@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"lorraxs/fivem_cdn_server/utils"
+	"time"
+)
+
+const purgeTimeout = time.Minute
+
+// fileUrls lists every public url that serves fileName: the file itself,
+// its thumbnail presets and, for clothing, its hash url. Resized variants
+// carry query parameters and still have to expire on their own.
+func (c *UploadController) fileUrls(fileName string, clothingHash string) []string {
+	urls := []string{utils.JoinURL(c.Config.App.BaseUrl, "static", fileName)}
+	for _, preset := range c.Config.Thumbnails.Presets {
+		if preset.Width <= 0 && preset.Height <= 0 {
+			continue
+		}
+		urls = append(urls, utils.JoinURL(c.Config.App.BaseUrl, "static", presetFileName(preset.Name, removedExt(fileName))))
+	}
+	if clothingHash != "" {
+		urls = append(urls, utils.JoinURL(c.Config.App.BaseUrl, "static", "hash", clothingHash))
+	}
+	return urls
+}
+
+// purge evicts urls from the CDN in the background, a CDN outage must not
+// fail the upload or delete that triggered it.
+func (c *UploadController) purge(urls []string) {
+	if len(urls) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), purgeTimeout)
+		defer cancel()
+		if err := c.Purger.Purge(ctx, urls); err != nil {
+			fmt.Println("Error purging the CDN:", err)
+		}
+	}()
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"lorraxs/fivem_cdn_server/storage"
+	"lorraxs/fivem_cdn_server/utils"
+	"net/http"
+	"path"
+
+	"github.com/chai2010/webp"
+	"github.com/gorilla/mux"
+)
+
+func presetFileName(preset string, baseName string) string {
+	return path.Join("presets", preset, baseName+".webp")
+}
+
+// generatePresets renders every configured thumbnail preset of img and
+// returns the names of the presets it stored. Presets without a size point
+// at the original upload and are not rendered.
+func (c *UploadController) generatePresets(ctx context.Context, baseName string, img image.Image) ([]string, error) {
+	generated := []string{}
+	for _, preset := range c.Config.Thumbnails.Presets {
+		if preset.Width <= 0 && preset.Height <= 0 {
+			continue
+		}
+		resized := utils.Resize(img, utils.ResizeOptions{Width: preset.Width, Height: preset.Height, Fit: utils.FitContain})
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, resized, &webp.Options{Quality: float32(c.Config.Images.DefaultQuality)}); err != nil {
+			return generated, fmt.Errorf("encoding preset %s: %w", preset.Name, err)
+		}
+		if err := c.Storage.Put(ctx, presetFileName(preset.Name, baseName), &buf, int64(buf.Len()), "image/webp"); err != nil {
+			return generated, fmt.Errorf("storing preset %s: %w", preset.Name, err)
+		}
+		generated = append(generated, preset.Name)
+	}
+	return generated, nil
+}
+
+func (c *UploadController) deletePresets(ctx context.Context, baseName string) error {
+	for _, preset := range c.Config.Thumbnails.Presets {
+		if preset.Width <= 0 && preset.Height <= 0 {
+			continue
+		}
+		err := c.Storage.Delete(ctx, presetFileName(preset.Name, baseName))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// presetUrls maps preset names to urls. Original-size presets always point
+// at the uploaded file, the others only when they were generated.
+func (c *UploadController) presetUrls(collection string, fileName string, generated []string) map[string]string {
+	urls := make(map[string]string)
+	baseName := removedExt(fileName)
+	for _, preset := range c.Config.Thumbnails.Presets {
+		if preset.Width <= 0 && preset.Height <= 0 {
+			urls[preset.Name] = c.staticUrl(collection, "static", fileName)
+			continue
+		}
+		for _, name := range generated {
+			if name == preset.Name {
+				urls[preset.Name] = c.staticUrl(collection, "static", presetFileName(preset.Name, baseName))
+				break
+			}
+		}
+	}
+	return urls
+}
+
+func (c *UploadController) GetStaticPresetFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	preset := vars["preset"]
+	file := vars["file"]
+	collection := ""
+	if len(c.Config.Signing.ProtectedCollections) > 0 {
+		if item, ok := c.Catalog.Snapshot().ByBaseName(removedExt(file)); ok {
+			collection = item.CollectionName
+		}
+	}
+	fileName := presetFileName(preset, removedExt(file))
+	if !c.checkSignature(w, r, collection, path.Join("static", fileName)) {
+		return
+	}
+	c.serveImage(w, r, fileName, c.cacheControl(r, collection, false))
+}
@@ -7,21 +7,30 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
 	"image/png"
-	"io"
+	"lorraxs/fivem_cdn_server/auth"
+	"lorraxs/fivem_cdn_server/cdn"
 	"lorraxs/fivem_cdn_server/config"
+	"lorraxs/fivem_cdn_server/events"
+	"lorraxs/fivem_cdn_server/jobs"
+	"lorraxs/fivem_cdn_server/loot"
+	"lorraxs/fivem_cdn_server/signing"
+	"lorraxs/fivem_cdn_server/storage"
 	"lorraxs/fivem_cdn_server/utils"
-	"math"
+	"lorraxs/fivem_cdn_server/webhooks"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/chai2010/webp"
 	"github.com/gorilla/mux"
-	"github.com/mazen160/go-random"
 )
 
 type ImageFile struct {
@@ -37,18 +46,22 @@ type BufferPayload struct {
 }
 
 type UploadResponse struct {
-	Success  bool   `json:"success"`
-	Message  string `json:"message"`
-	FileName string `json:"fileName"`
-	Url      string `json:"url"`
+	Success   bool              `json:"success"`
+	Message   string            `json:"message"`
+	FileName  string            `json:"fileName"`
+	Url       string            `json:"url"`
+	Presets   map[string]string `json:"presets,omitempty"`
+	JobId     int64             `json:"jobId,omitempty"`
+	StatusUrl string            `json:"statusUrl,omitempty"`
 }
 
 type UploadManifestCollectionItemTexture struct {
-	TextureId string `json:"textureId"`
-	Name      string `json:"name"`
-	Url       string `json:"url"`
-	Size      int    `json:"size"`
-	Hash      string `json:"hash"`
+	TextureId string            `json:"textureId"`
+	Name      string            `json:"name"`
+	Url       string            `json:"url"`
+	Size      int               `json:"size"`
+	Hash      string            `json:"hash"`
+	Presets   map[string]string `json:"presets,omitempty"`
 }
 
 type UploadManifestCollectionItem struct {
@@ -73,15 +86,19 @@ type UploadManifestResponse struct {
 }
 
 type ClothingItem struct {
-	CollectionName string  `json:"cn"`
-	Gender         int     `json:"g"`
-	ComponentType  string  `json:"ct"`
-	ComponentId    int     `json:"ci"`
-	DrawableId     int     `json:"di"`
-	TextureId      int     `json:"ti"`
-	Size           int     `json:"s"`
-	Hash           string  `json:"h"`
-	Price          float64 `json:"p"`
+	CollectionName string            `json:"cn"`
+	Gender         int               `json:"g"`
+	ComponentType  string            `json:"ct"`
+	ComponentId    int               `json:"ci"`
+	DrawableId     int               `json:"di"`
+	TextureId      int               `json:"ti"`
+	Size           int               `json:"s"`
+	Hash           string            `json:"h"`
+	Price          float64           `json:"p"`
+	Url            string            `json:"u,omitempty"`
+	Presets        map[string]string `json:"pr,omitempty"`
+	FileName       string            `json:"-"`
+	PresetNames    []string          `json:"-"`
 }
 
 type ClothingPrice struct {
@@ -90,94 +107,89 @@ type ClothingPrice struct {
 }
 
 type UploadController struct {
-	ctx                context.Context
-	Router             *mux.Router
-	Config             *config.Config
-	DB                 *sql.DB
-	CachedClothing     []*ClothingItem
-	CachedHashClothing map[string]*ClothingItem
+	ctx      context.Context
+	Router   *mux.Router
+	Config   *config.Config
+	DB       *sql.DB
+	Storage  storage.Storage
+	Auth     *auth.Middleware
+	Signer   *signing.Signer
+	Jobs     *jobs.Queue
+	Purger   cdn.Purger
+	Webhooks *webhooks.Dispatcher
+	Events   *events.Broker
+	Catalog  *CatalogCache
+	Loot     *loot.Store
+	variants *variantCache
+	// held are the files the server is changing itself, see holdFile.
+	held fileHolds
+}
+
+// Dependencies are the shared services main hands to the controller.
+type Dependencies struct {
+	DB       *sql.DB
+	Storage  storage.Storage
+	Auth     *auth.Middleware
+	Jobs     *jobs.Queue
+	Purger   cdn.Purger
+	Webhooks *webhooks.Dispatcher
+	Events   *events.Broker
 }
 
 func NewUploadController() *UploadController {
 	return &UploadController{}
 }
 
-func (c *UploadController) Init(ctx context.Context, router *mux.Router, db *sql.DB) {
-	c.DB = db
+// Setup wires the controller dependencies without registering any routes,
+// which is all the maintenance commands need.
+func (c *UploadController) Setup(ctx context.Context, deps Dependencies) {
+	c.DB = deps.DB
+	c.Storage = deps.Storage
+	c.Auth = deps.Auth
+	c.Jobs = deps.Jobs
+	c.Purger = deps.Purger
+	c.Webhooks = deps.Webhooks
+	c.Events = deps.Events
+	if c.Purger == nil {
+		c.Purger = cdn.Noop{}
+	}
 	c.ctx = ctx
-	c.Router = router
 	c.Config = config.GetConfig()
-	clothing, err := c.GetClothing("null", true)
-	if err != nil {
+	c.Signer = signing.NewSigner(c.Config.Signing.Secret, c.Config.Signing.Ttl)
+	c.Catalog = NewCatalogCache()
+	c.Loot = loot.NewStore(c.DB)
+}
+
+func (c *UploadController) Init(ctx context.Context, router *mux.Router, deps Dependencies) {
+	c.Setup(ctx, deps)
+	c.Router = router
+	c.Jobs.Register(uploadJobType, c.runUploadJob)
+	c.variants = newVariantCache(c.Config.Images.CachePath, int64(c.Config.Images.CacheMaxSizeMb)<<20)
+	if err := c.reloadCatalog(); err != nil {
 		fmt.Println("Error getting clothing:", err)
 		return
 	}
-	c.CachedClothing = clothing.([]*ClothingItem)
-	c.CachedHashClothing = make(map[string]*ClothingItem)
-	for _, item := range c.CachedClothing {
-		c.CachedHashClothing[item.Hash] = item
+	if c.Config.Watcher.Enabled && (c.Config.Storage.Driver == "" || c.Config.Storage.Driver == "local") {
+		if err := c.watchUploads(ctx); err != nil {
+			fmt.Println("Error watching the upload directory:", err)
+		}
 	}
-	c.Router.HandleFunc("/static/{file}", c.DeleteStaticFile).Methods("DELETE")
-	c.Router.HandleFunc("/upload", c.Upload).Methods("POST")
+	c.Auth.Protect(c.Router.HandleFunc("/static/{file}", c.DeleteStaticFile).Methods("DELETE"), auth.ScopeDelete)
+	c.Auth.Protect(c.Router.HandleFunc("/upload", c.Upload).Methods("POST"), auth.ScopeUpload)
 	c.Router.HandleFunc("/upload/manifest", c.GetUploadManifest).Methods("GET")
+	c.Auth.Protect(c.Router.HandleFunc("/upload/duplicates", c.GetDuplicates).Methods("GET"), auth.ScopeUpload)
 
-	c.Router.HandleFunc("/upload/collection", func(w http.ResponseWriter, r *http.Request) {
-		secret := r.Header.Get("Secret")
-		if secret != c.Config.App.Secret {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		collection := r.URL.Query().Get("collection")
-		if collection == "" {
-			http.Error(w, "Collection name is required", http.StatusBadRequest)
-			return
-		}
-		for _, clothingItem := range c.CachedClothing {
-			if clothingItem.CollectionName == collection {
-				fileName := fmt.Sprintf("%s-%s-%s-%s-%s-%s.webp", clothingItem.CollectionName, strconv.Itoa(clothingItem.Gender), clothingItem.ComponentType, strconv.Itoa(clothingItem.ComponentId), strconv.Itoa(clothingItem.DrawableId), strconv.Itoa(clothingItem.TextureId))
-				filePath := utils.JoinURL(c.Config.App.UploadPath, fileName)
-				err := os.Remove(filePath)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				fmt.Printf("Deleted file: %s\n", filePath)
-				delete(c.CachedHashClothing, clothingItem.Hash)
-			}
-		}
-		clothing, err := c.GetClothing("null", true)
-		if err != nil {
-			http.Error(w, "Error getting clothing", http.StatusInternalServerError)
-			return
-		}
-		c.CachedClothing = clothing.([]*ClothingItem)
-		for _, item := range c.CachedClothing {
-			c.CachedHashClothing[item.Hash] = item
-		}
-	}).Methods("DELETE")
+	c.Auth.Protect(c.Router.HandleFunc("/upload/collection/import", c.ImportCollection).Methods("POST"), auth.ScopeUpload)
+	c.Auth.Protect(c.Router.HandleFunc("/upload/collection", c.DeleteCollection).Methods("DELETE"), auth.ScopeDelete)
 
-	c.Router.HandleFunc("/upload/clothing/flush_cache", func(w http.ResponseWriter, r *http.Request) {
-		secret := r.Header.Get("Secret")
-		if secret != c.Config.App.Secret {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-		clothing, err := c.GetClothing("null", true)
-		if err != nil {
+	c.Auth.Protect(c.Router.HandleFunc("/upload/clothing/flush_cache", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.reloadCatalog(); err != nil {
 			http.Error(w, "Error getting clothing", http.StatusInternalServerError)
 			return
 		}
-		c.CachedClothing = clothing.([]*ClothingItem)
-		for _, item := range c.CachedClothing {
-			c.CachedHashClothing[item.Hash] = item
-		}
-	}).Methods("GET")
-	c.Router.HandleFunc("/upload/clothing/update_price", func(w http.ResponseWriter, r *http.Request) {
-		secret := r.Header.Get("Secret")
-		if secret != c.Config.App.Secret {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+		c.emit(webhooks.EventCacheFlushed, CacheFlushedEvent{Items: c.Catalog.Snapshot().Len()})
+	}).Methods("GET"), auth.ScopeCacheAdmin)
+	c.Auth.Protect(c.Router.HandleFunc("/upload/clothing/update_price", func(w http.ResponseWriter, r *http.Request) {
 		hash := r.URL.Query().Get("hash")
 		price := r.URL.Query().Get("price")
 		if hash == "" || price == "" {
@@ -194,15 +206,8 @@ func (c *UploadController) Init(ctx context.Context, router *mux.Router, db *sql
 			http.Error(w, "Error updating price", http.StatusInternalServerError)
 			return
 		}
-		clothing, err := c.GetClothing("null", true)
-		if err != nil {
-			http.Error(w, "Error getting clothing", http.StatusInternalServerError)
-			return
-		}
-		c.CachedClothing = clothing.([]*ClothingItem)
-		for _, item := range c.CachedClothing {
-			c.CachedHashClothing[item.Hash] = item
-		}
+		c.Catalog.Reprice(hash, priceFloat)
+		c.emit(webhooks.EventPriceUpdated, PriceEvent{Hash: hash, Price: priceFloat})
 		response := struct {
 			Success bool `json:"success"`
 			Data    any  `json:"data"`
@@ -212,110 +217,35 @@ func (c *UploadController) Init(ctx context.Context, router *mux.Router, db *sql
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
-	}).Methods("POST")
-
-	c.Router.HandleFunc("/upload/clothing/random", func(w http.ResponseWriter, r *http.Request) {
-		componentType := r.URL.Query().Get("componentType")
-		componentId := r.URL.Query().Get("componentId")
-		ratef := r.URL.Query().Get("rate")
-		fmt.Printf("componentType: %s, componentId: %s, rate: %s\n", componentType, componentId, ratef)
-		response := struct {
-			Success bool `json:"success"`
-			Data    any  `json:"data"`
-		}{
-			Success: false,
-		}
-		if componentType == "" || componentId == "" || ratef == "" {
-			http.Error(w, "Missing required parameters", http.StatusBadRequest)
-			return
-		}
-		rate, err := strconv.ParseFloat(ratef, 64)
-		if err != nil {
-			http.Error(w, "Invalid rate parameter", http.StatusBadRequest)
-			return
-		}
-		componentIdInt, err := strconv.Atoi(componentId)
-		if err != nil {
-			http.Error(w, "Invalid componentId parameter", http.StatusBadRequest)
-			return
-		}
-		rd1, err := random.IntRange(0, 10000)
-		if err != nil {
-			http.Error(w, "Error generating random number", http.StatusInternalServerError)
-			return
-		}
-		var rd1f float64 = float64(rd1) / 100
-		fmt.Printf("%f - %f", rd1f, rate)
-		if rd1f > rate {
-			response.Success = false
-			response.Data = "Rate not met"
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
-		var item *ClothingItem
-		var items []*ClothingItem
-		totalPrice := 0.0
-		minPrice := math.MaxFloat64
-		maxPrice := 0.0
-		for _, clothingItem := range c.CachedClothing {
-			if clothingItem.ComponentType == componentType && clothingItem.ComponentId == componentIdInt {
-				if clothingItem.Price > 0 {
-					items = append(items, clothingItem)
-					totalPrice += clothingItem.Price
-					if clothingItem.Price < minPrice {
-						minPrice = clothingItem.Price
-					}
-					if clothingItem.Price > maxPrice {
-						maxPrice = clothingItem.Price
-					}
-				}
-			}
-		}
-		minMax := maxPrice - minPrice
-		rd, err := random.IntRange(0, int(totalPrice))
-		if err != nil {
-			http.Error(w, "Error generating random number", http.StatusInternalServerError)
-			return
-		}
-		curRate := 0.0
-		for _, clothingItem := range items {
-			if math.Abs(minMax-clothingItem.Price)+curRate >= float64(rd) {
-				item = clothingItem
-				break
-			}
-			curRate += math.Abs(minMax - clothingItem.Price)
-		}
-		if item == nil {
-			http.Error(w, "Item not found", http.StatusNotFound)
-			return
-		}
-		response.Success = true
-		response.Data = item
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
+	}).Methods("POST"), auth.ScopePriceWrite)
 
-	}).Methods("GET")
+	c.Router.HandleFunc("/upload/clothing/random", c.GetRandomClothing).Methods("GET")
+	c.Router.HandleFunc("/upload/clothing/random/odds", c.GetRandomClothingOdds).Methods("GET")
+	c.Router.HandleFunc("/upload/clothing/random/batch", c.PostRandomClothingBatch).Methods("POST")
+	c.Auth.Protect(c.Router.HandleFunc("/loot/tables", c.GetLootTables).Methods("GET"), auth.ScopeLootAdmin)
+	c.Auth.Protect(c.Router.HandleFunc("/loot/tables/{name}", c.GetLootTable).Methods("GET"), auth.ScopeLootAdmin)
+	c.Auth.Protect(c.Router.HandleFunc("/loot/tables/{name}", c.PutLootTable).Methods("PUT"), auth.ScopeLootAdmin)
+	c.Auth.Protect(c.Router.HandleFunc("/loot/tables/{name}", c.DeleteLootTable).Methods("DELETE"), auth.ScopeLootAdmin)
 
 	c.Router.HandleFunc("/upload/clothing/{hash}", func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		hash := vars["hash"]
-		var item *ClothingItem
-		for _, clothingItem := range c.CachedClothing {
-			if clothingItem.Hash == hash {
-				item = clothingItem
-				break
-			}
-		}
-		if item == nil {
+		item, ok := c.Catalog.Snapshot().ByHash(hash)
+		if !ok {
 			http.Error(w, "Item not found", http.StatusNotFound)
 			return
 		}
-		json.NewEncoder(w).Encode(item)
+		json.NewEncoder(w).Encode(c.withSignedUrl(item))
 	}).Methods("GET")
 
-	c.Router.HandleFunc("/upload-buffer", c.UploadBuffer).Methods("POST")
+	c.Auth.Protect(c.Router.HandleFunc("/upload-buffer", c.UploadBuffer).Methods("POST"), auth.ScopeUpload)
 	c.Router.HandleFunc("/static/hash/{hash}", c.GetStaticHashedFile).Methods("GET")
+	c.Router.HandleFunc("/static/presets/{preset}/{file}", c.GetStaticPresetFile).Methods("GET")
+	c.Auth.Protect(c.Router.HandleFunc("/jobs/{id:[0-9]+}", c.GetJob).Methods("GET"), auth.ScopeUpload)
+	c.Auth.Protect(c.Router.HandleFunc("/webhooks/deliveries", c.GetWebhookDeliveries).Methods("GET"), auth.ScopeWebhookAdmin)
+	if c.Events != nil {
+		c.Router.Handle("/events", c.Events).Methods("GET")
+	}
 	c.Router.HandleFunc("/static/{file}", c.GetStaticFile).Methods("GET")
 }
 
@@ -392,219 +322,76 @@ func mustParseInt(s string) int {
 }
 
 func (c *UploadController) GetClothing(collection string, returnSet bool) (interface{}, error) {
-	files, err := os.ReadDir(c.Config.App.UploadPath)
+	items, err := c.GetClothingItems(c.ctx, collection)
 	if err != nil {
 		return nil, err
 	}
 	if returnSet {
-		prices, err := c.GetClothingPrices()
-		if err != nil {
-			return nil, err
-		}
-		priceMap := make(map[string]float64)
-		for _, price := range prices {
-			priceMap[price.Hash] = price.Price
-		}
-		// Create a map to store the clothing items
-		response := []*ClothingItem{}
-		for _, file := range files {
-			if file.IsDir() {
-				continue
-			}
-			// filename Male_Apt01_male_component_11_6_1.webp
-
-			ext := strings.ToLower(filepath.Ext(file.Name()))
-			if ext != ".webp" {
-				continue
-			}
-			// Get the file name without the extension
-			name := removedExt(file.Name())
-
-			// Split the file name into parts
-			parts := strings.Split(name, "-")
-			if len(parts) < 6 {
-				continue
-			}
-
-			textureId := parts[len(parts)-1]
-			drawableId := parts[len(parts)-2]
-			componentId := parts[len(parts)-3]
-			componentType := parts[len(parts)-4]
-			gender := parts[len(parts)-5]
-			collectionName := ""
-			collectionPartLen := len(parts) - 6
-			for i := 0; i <= collectionPartLen; i++ {
-				collectionName += parts[i]
-				if i < collectionPartLen {
-					collectionName += "-"
-				}
-			}
-
-			if collection != "null" && collection != collectionName {
-				continue
-			}
-
-			fi, err := file.Info()
-
-			if err != nil {
-				continue
-			}
+		return items, nil
+	}
 
-			/* if fi.Size() < 1024*4 {
-				if componentType != "prop" && componentId != "2" && componentId != "7" {
-					continue
-				}
-				if fi.Size() == 3904 {
-					continue
-				}
-				if componentType == "component" && componentId == "7" && fi.Size() < 1024 {
-					continue
-				}
-			} */
-			price := 0.0
-			hash := generateTextureHash(collectionName, componentType, componentId, drawableId, textureId, gender)
-			if priceValue, ok := priceMap[hash]; ok {
-				price = priceValue
-			}
-			intGender := 0
-			if gender == "0" {
-				intGender = 0
-			} else {
-				intGender = 1
-			}
-			item := ClothingItem{
-				CollectionName: collectionName,
-				Gender:         intGender,
-				ComponentType:  componentType,
-				ComponentId:    mustParseInt(componentId),
-				DrawableId:     mustParseInt(drawableId),
-				TextureId:      mustParseInt(textureId),
-				Size:           int(fi.Size()),
-				Hash:           hash,
-				Price:          price,
+	response := UploadManifestResponse{
+		CollectionNum: 0,
+		Collections:   []UploadManifestCollection{},
+	}
+	collectionIndex := make(map[string]int)
+
+	for _, clothingItem := range items {
+		name := removedExt(clothingItem.FileName)
+		gender := strconv.Itoa(clothingItem.Gender)
+		componentId := strconv.Itoa(clothingItem.ComponentId)
+		drawableId := strconv.Itoa(clothingItem.DrawableId)
+
+		// Check if the collection already exists
+		i, ok := collectionIndex[clothingItem.CollectionName]
+		if !ok {
+			response.Collections = append(response.Collections, UploadManifestCollection{
+				CollectionName: clothingItem.CollectionName,
+				Items:          []UploadManifestCollectionItem{},
+			})
+			i = len(response.Collections) - 1
+			collectionIndex[clothingItem.CollectionName] = i
+			response.CollectionNum++
+		}
+		manifestCollection := &response.Collections[i]
+
+		// Check if the item already exists
+		var item *UploadManifestCollectionItem
+		for j := range manifestCollection.Items {
+			if manifestCollection.Items[j].ComponentId == componentId && manifestCollection.Items[j].DrawableId == drawableId && manifestCollection.Items[j].Gender == gender && manifestCollection.Items[j].ComponentType == clothingItem.ComponentType {
+				item = &manifestCollection.Items[j]
+				break
 			}
-			response = append(response, &item)
 		}
-		return response, nil
-	} else {
 
-		response := UploadManifestResponse{
-			CollectionNum: 0,
-			Collections:   []UploadManifestCollection{},
-		}
-
-		for _, file := range files {
-			if file.IsDir() {
-				continue
-			}
-			// filename Male_Apt01_male_component_11_6_1.webp
-
-			ext := strings.ToLower(filepath.Ext(file.Name()))
-			if ext != ".webp" {
-				continue
-			}
-			// Get the file name without the extension
-			name := removedExt(file.Name())
-
-			// Split the file name into parts
-			parts := strings.Split(name, "-")
-			if len(parts) < 6 {
-				continue
-			}
-
-			textureId := parts[len(parts)-1]
-			drawableId := parts[len(parts)-2]
-			componentId := parts[len(parts)-3]
-			componentType := parts[len(parts)-4]
-			gender := parts[len(parts)-5]
-			collectionName := ""
-			collectionPartLen := len(parts) - 6
-			for i := 0; i <= collectionPartLen; i++ {
-				collectionName += parts[i]
-				if i < collectionPartLen {
-					collectionName += "-"
-				}
-			}
-
-			if collection != "null" && collection != collectionName {
-				continue
-			}
-
-			fi, err := file.Info()
-
-			if err != nil {
-				continue
-			}
-
-			/* if fi.Size() < 1024*4 {
-				if componentType != "prop" && componentId != "2" && componentId != "7" {
-					continue
-				}
-				if fi.Size() == 3904 {
-					continue
-				}
-				if componentType == "component" && componentId == "7" && fi.Size() < 1024 {
-					continue
-				}
-			} */
-
-			// Check if the collection already exists
-			var collection *UploadManifestCollection
-			for i := range response.Collections {
-				if response.Collections[i].CollectionName == collectionName {
-					collection = &response.Collections[i]
-					break
-				}
-			}
-
-			if collection == nil {
-				collection = &UploadManifestCollection{
-					CollectionName: collectionName,
-					Items:          []UploadManifestCollectionItem{},
-				}
-				response.Collections = append(response.Collections, *collection)
-				response.CollectionNum++
-			}
-
-			// Check if the item already exists
-			var item *UploadManifestCollectionItem
-			for i := range collection.Items {
-				if collection.Items[i].ComponentId == componentId && collection.Items[i].DrawableId == drawableId && collection.Items[i].Gender == gender && collection.Items[i].ComponentType == componentType {
-					item = &collection.Items[i]
-					break
-				}
-			}
-
-			url := utils.JoinURL(c.Config.App.BaseUrl, "static", file.Name())
-
-			texture := UploadManifestCollectionItemTexture{
-				TextureId: textureId,
-				Name:      name,
-				Url:       url,
-				Size:      int(fi.Size()),
-				Hash:      generateTextureHash(collectionName, componentType, componentId, drawableId, textureId, gender),
-			}
-
-			if item == nil {
-				item = &UploadManifestCollectionItem{
-					CollectionName: collectionName,
-					Gender:         gender,
-					ComponentType:  componentType,
-					ComponentId:    componentId,
-					DrawableId:     drawableId,
-					Name:           name,
-					Url:            url,
-					Textures:       []UploadManifestCollectionItemTexture{texture},
-				}
-				collection.Items = append(collection.Items, *item)
-			}
-
-			item.Textures = append(item.Textures, texture)
+		url := c.staticUrl(clothingItem.CollectionName, "static", clothingItem.FileName)
 
+		texture := UploadManifestCollectionItemTexture{
+			TextureId: strconv.Itoa(clothingItem.TextureId),
+			Name:      name,
+			Url:       url,
+			Size:      clothingItem.Size,
+			Hash:      clothingItem.Hash,
+			Presets:   c.presetUrls(clothingItem.CollectionName, clothingItem.FileName, clothingItem.PresetNames),
 		}
-		return response, nil
-	}
 
+		if item == nil {
+			manifestCollection.Items = append(manifestCollection.Items, UploadManifestCollectionItem{
+				CollectionName: clothingItem.CollectionName,
+				Gender:         gender,
+				ComponentType:  clothingItem.ComponentType,
+				ComponentId:    componentId,
+				DrawableId:     drawableId,
+				Name:           name,
+				Url:            url,
+				Textures:       []UploadManifestCollectionItemTexture{},
+			})
+			item = &manifestCollection.Items[len(manifestCollection.Items)-1]
+		}
+
+		item.Textures = append(item.Textures, texture)
+	}
+	return response, nil
 }
 
 func (c *UploadController) GetUploadManifest(w http.ResponseWriter, r *http.Request) {
@@ -622,15 +409,20 @@ func (c *UploadController) GetUploadManifest(w http.ResponseWriter, r *http.Requ
 				return
 			}
 			response := []*ClothingItem{}
-			for _, clothingItem := range c.CachedClothing {
+			for _, clothingItem := range c.Catalog.Snapshot().Items() {
 				if clothingItem.Price >= fromPriceFloat {
-					response = append(response, clothingItem)
+					response = append(response, c.withSignedUrl(clothingItem))
 				}
 			}
 			json.NewEncoder(w).Encode(response)
 			return
 		} else {
-			response = c.CachedClothing
+			catalog := c.Catalog.Snapshot().Items()
+			items := make([]*ClothingItem, len(catalog))
+			for i, clothingItem := range catalog {
+				items[i] = c.withSignedUrl(clothingItem)
+			}
+			response = items
 		}
 	} else {
 
@@ -644,11 +436,70 @@ func (c *UploadController) GetUploadManifest(w http.ResponseWriter, r *http.Requ
 	json.NewEncoder(w).Encode(response)
 }
 
+func (c *UploadController) requiresSignature(collection string) bool {
+	for _, protected := range c.Config.Signing.ProtectedCollections {
+		if protected == collection {
+			return true
+		}
+	}
+	return false
+}
+
+// staticUrl builds a public url for a resource below the base url, signed
+// when the collection requires it.
+func (c *UploadController) staticUrl(collection string, paths ...string) string {
+	resource := path.Join(paths...)
+	url := utils.JoinURL(c.Config.App.BaseUrl, resource)
+	if c.requiresSignature(collection) {
+		url = c.Signer.SignURL(url, resource)
+	}
+	return url
+}
+
+// withSignedUrl returns a copy of item carrying a signed hash url when its
+// collection is protected, the cached item itself is never modified.
+func (c *UploadController) withSignedUrl(item *ClothingItem) *ClothingItem {
+	if !c.requiresSignature(item.CollectionName) {
+		return item
+	}
+	signed := *item
+	signed.Url = c.staticUrl(item.CollectionName, "static", "hash", item.Hash)
+	signed.Presets = c.presetUrls(item.CollectionName, item.FileName, item.PresetNames)
+	return &signed
+}
+
+// checkSignature validates the signature of a static request. Signatures
+// are always checked when present, and required for protected collections.
+func (c *UploadController) checkSignature(w http.ResponseWriter, r *http.Request, collection string, resource string) bool {
+	query := r.URL.Query()
+	if !signing.Signed(query) && !c.requiresSignature(collection) {
+		return true
+	}
+	if err := c.Signer.Verify(resource, query); err != nil {
+		http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (c *UploadController) findByFileName(fileName string) *ClothingItem {
+	item, _ := c.Catalog.Snapshot().ByFileName(fileName)
+	return item
+}
+
 func (c *UploadController) GetStaticFile(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	file := vars["file"]
-	filePath := utils.JoinURL(c.Config.App.UploadPath, file)
-	http.ServeFile(w, r, filePath)
+	collection := ""
+	if len(c.Config.Signing.ProtectedCollections) > 0 {
+		if item := c.findByFileName(file); item != nil {
+			collection = item.CollectionName
+		}
+	}
+	if !c.checkSignature(w, r, collection, path.Join("static", file)) {
+		return
+	}
+	c.serveImage(w, r, file, c.cacheControl(r, collection, false))
 }
 
 func (c *UploadController) GetStaticHashedFile(w http.ResponseWriter, r *http.Request) {
@@ -658,38 +509,148 @@ func (c *UploadController) GetStaticHashedFile(w http.ResponseWriter, r *http.Re
 		http.Error(w, "Hash is required", http.StatusBadRequest)
 		return
 	}
-	if file, ok := c.CachedHashClothing[hash]; ok {
-		filePath := utils.JoinURL(c.Config.App.UploadPath, fmt.Sprintf("%s-%s-%s-%s-%s-%s.webp", file.CollectionName, strconv.Itoa(file.Gender), file.ComponentType, strconv.Itoa(file.ComponentId), strconv.Itoa(file.DrawableId), strconv.Itoa(file.TextureId)))
-		http.ServeFile(w, r, filePath)
+	if file, ok := c.Catalog.Snapshot().ByHash(hash); ok {
+		if !c.checkSignature(w, r, file.CollectionName, path.Join("static", "hash", hash)) {
+			return
+		}
+		c.serveImage(w, r, file.FileName, c.cacheControl(r, file.CollectionName, true))
+		return
 	}
+	http.NotFound(w, r)
 }
 
 func (c *UploadController) DeleteStaticFile(w http.ResponseWriter, r *http.Request) {
-	secret := r.Header.Get("Secret")
-	if secret != c.Config.App.Secret {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
 	vars := mux.Vars(r)
 	file := vars["file"]
-	filePath := utils.JoinURL(c.Config.App.UploadPath, file)
-	err := os.Remove(filePath)
+	defer c.holdFile(file)()
+	err := c.Storage.Delete(r.Context(), file)
 	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := c.forgetFile(r.Context(), file); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+type DeleteCollectionResponse struct {
+	Success    bool   `json:"success"`
+	Collection string `json:"collection"`
+	Deleted    int    `json:"deleted"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DeleteCollection deletes every texture of a collection one by one, each
+// leaves storage, the database and the catalog before the next is touched,
+// so a failure part way leaves no texture half deleted.
+func (c *UploadController) DeleteCollection(w http.ResponseWriter, r *http.Request) {
+	collection := r.URL.Query().Get("collection")
+	if collection == "" {
+		http.Error(w, "Collection name is required", http.StatusBadRequest)
+		return
+	}
+	response := DeleteCollectionResponse{Success: true, Collection: collection}
+	status := http.StatusOK
+	purged := []string{}
+	for _, clothingItem := range c.Catalog.Snapshot().ByCollection(collection) {
+		if err := c.deleteCollectionItem(r.Context(), clothingItem); err != nil {
+			fmt.Printf("Error deleting %s: %s\n", clothingItem.FileName, err)
+			response.Success = false
+			response.Error = fmt.Sprintf("deleting %s: %s", clothingItem.FileName, err)
+			status = http.StatusInternalServerError
+			break
+		}
+		purged = append(purged, c.fileUrls(clothingItem.FileName, clothingItem.Hash)...)
+		response.Deleted++
+		fmt.Printf("Deleted file: %s\n", clothingItem.FileName)
+	}
+	c.purge(purged)
+	if response.Deleted > 0 {
+		c.emit(webhooks.EventCollectionDeleted, CollectionEvent{Collection: collection, Files: response.Deleted})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *UploadController) deleteCollectionItem(ctx context.Context, item *ClothingItem) error {
+	defer c.holdFile(item.FileName)()
+	if err := c.Storage.Delete(ctx, item.FileName); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if err := c.deletePresets(ctx, removedExt(item.FileName)); err != nil {
+		return err
+	}
+	if err := c.DeleteClothingItemByFileName(ctx, item.FileName); err != nil {
+		return err
+	}
+	c.Catalog.Remove(item.FileName)
+	return nil
+}
+
+// forgetFile cleans up after a stored file is gone: its thumbnails, catalog
+// entry and CDN copies.
+func (c *UploadController) forgetFile(ctx context.Context, file string) error {
+	clothingHash := ""
+	if item := c.findByFileName(file); item != nil {
+		clothingHash = item.Hash
+	}
+	defer c.purge(c.fileUrls(file, clothingHash))
+	if err := c.deletePresets(ctx, removedExt(file)); err != nil {
+		return err
+	}
+	if err := c.DeleteClothingItemByFileName(ctx, file); err != nil {
+		return err
+	}
+	c.Catalog.Remove(file)
+	c.emit(webhooks.EventTextureDeleted, TextureEvent{
+		FileName: file,
+		Url:      utils.JoinURL(c.Config.App.BaseUrl, "static", file),
+		Hash:     clothingHash,
+	})
+	return nil
+}
+
+// putWebp encodes img to WebP and stores it under name, returning the
+// stored size.
+func (c *UploadController) putWebp(ctx context.Context, name string, img image.Image) (int64, error) {
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, nil); err != nil {
+		return 0, fmt.Errorf("encoding the image to WebP format: %w", err)
+	}
+	size := int64(buf.Len())
+	return size, c.Storage.Put(ctx, name, &buf, size, "image/webp")
+}
+
 func removedExt(f string) string {
 	return strings.TrimSuffix(f, filepath.Ext(f))
 }
 
+// chromaKeyFromQuery returns the background removal options when rmbg=true,
+// or nil when the background should be kept.
+func (c *UploadController) chromaKeyFromQuery(query url.Values) (*utils.ChromaKeyOptions, error) {
+	if query.Get("rmbg") != "true" {
+		return nil, nil
+	}
+	defaults := utils.DefaultChromaKeyOptions()
+	defaults.MinArea = c.Config.Images.MinParticleArea
+	opts, err := utils.ParseChromaKeyOptions(query, defaults)
+	if err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
+
 func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 	// max total size 20mb
 	r.ParseMultipartForm(200 << 20)
 
-	removeBackground := r.URL.Query().Get("rmbg")
 	fileName := r.URL.Query().Get("name")
 
 	if fileName == "" {
@@ -697,11 +658,9 @@ func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	secret := r.Header.Get("Secret")
-	fmt.Printf("Secret: %s\n", secret)
-
-	if secret != c.Config.App.Secret {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	chromaKey, err := c.chromaKeyFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -720,9 +679,38 @@ func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 		Url:      utils.JoinURL(c.Config.App.BaseUrl, "static", h.Filename),
 	}
 
+	var clothing *ClothingFile
+	if clothingFile, ok := clothingFileFromQuery(r.URL.Query(), fileName); ok {
+		clothing = &clothingFile
+	}
+
+	canvas, err := c.canvasFromQuery(r.URL.Query(), clothing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stored *StoredFile
 	ext := strings.ToLower(filepath.Ext(h.Filename))
 	switch ext {
 	case ".png":
+		if c.wantsAsync(r) {
+			job, err := c.enqueueUpload(r.Context(), f, h.Size, uploadJobPayload{
+				BaseName:         fileName,
+				RemoveBackground: chromaKey != nil,
+				ChromaKey:        chromaKey,
+				Canvas:           canvas,
+				Clothing:         clothing,
+			})
+			if err != nil {
+				errStr := fmt.Sprintf("Error queueing the upload. Reason: %s\n", err)
+				fmt.Println(errStr)
+				http.Error(w, errStr, http.StatusInternalServerError)
+				return
+			}
+			c.writeJobAccepted(w, h.Filename, job)
+			return
+		}
 		// Decode the PNG image
 		img, err := png.Decode(f)
 		if err != nil {
@@ -731,61 +719,39 @@ func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
-		if removeBackground == "true" {
-			img, err = utils.RemoveGreenBackground(img)
-			if err != nil {
-				errStr := fmt.Sprintf("Error removing the green background. Reason: %s\n", err)
-				fmt.Println(errStr)
-				http.Error(w, errStr, http.StatusInternalServerError)
-				return
-			}
-			/* img, err = utils.TrimImage(img)
-			if err != nil {
-				errStr := fmt.Sprintf("Error trimming the image. Reason: %s\n", err)
-				fmt.Println(errStr)
-				http.Error(w, errStr, http.StatusInternalServerError)
-				return
-			} */
-		}
-		// Create the WebP file
-		webpFilePath := utils.JoinURL(c.Config.App.UploadPath, fileName+".webp")
-		webpFile, err := os.Create(webpFilePath)
+		img, err = prepareImage(img, chromaKey, canvas)
 		if err != nil {
-			errStr := fmt.Sprintf("Error in creating the WebP file. Reason: %s\n", err)
+			errStr := fmt.Sprintf("Error processing the image. Reason: %s\n", err)
 			fmt.Println(errStr)
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
-		defer webpFile.Close()
-
-		// Encode the image to WebP format and save it
-		var buf bytes.Buffer
-		if err := webp.Encode(&buf, img, nil); err != nil {
-			errStr := fmt.Sprintf("Error encoding the image to WebP format. Reason: %s\n", err)
+		stored, err = c.storeWebp(r.Context(), fileName, img, clothing)
+		if err != nil {
+			errStr := fmt.Sprintf("Error storing the upload. Reason: %s\n", err)
 			fmt.Println(errStr)
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
+		response.Url = utils.JoinURL(c.Config.App.BaseUrl, "static", stored.Name)
 
-		if _, err := webpFile.Write(buf.Bytes()); err != nil {
-			errStr := fmt.Sprintf("Error writing the WebP file. Reason: %s\n", err)
+	case ".webp", ".jpg", ".jpeg":
+		// Save the file directly
+		if _, err := f.Seek(0, 0); err != nil {
+			errStr := fmt.Sprintf("Error seeking the file. Reason: %s\n", err)
 			fmt.Println(errStr)
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
-		response.Url = utils.JoinURL(c.Config.App.BaseUrl, "static", fileName+".webp")
 
-	case ".webp", ".jpg", ".jpeg":
-		// Save the file directly
-		saveFilePath := utils.JoinURL(c.Config.App.UploadPath, fileName+ext)
-		saveFile, err := os.Create(saveFilePath)
-		if err != nil {
-			errStr := fmt.Sprintf("Error in creating the file. Reason: %s\n", err)
+		stored = &StoredFile{Name: fileName + ext, Size: h.Size, Mime: mime.TypeByExtension(ext), Clothing: clothing}
+		defer c.holdFile(stored.Name)()
+		if err := c.Storage.Put(r.Context(), stored.Name, f, stored.Size, stored.Mime); err != nil {
+			errStr := fmt.Sprintf("Error saving the file. Reason: %s\n", err)
 			fmt.Println(errStr)
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
-		defer saveFile.Close()
 
 		if _, err := f.Seek(0, 0); err != nil {
 			errStr := fmt.Sprintf("Error seeking the file. Reason: %s\n", err)
@@ -793,9 +759,15 @@ func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
 		}
-
-		if _, err := io.Copy(saveFile, f); err != nil {
-			errStr := fmt.Sprintf("Error saving the file. Reason: %s\n", err)
+		img, _, err := image.Decode(f)
+		if err != nil {
+			errStr := fmt.Sprintf("Error decoding the image. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		if err := c.finishStore(r.Context(), stored, img); err != nil {
+			errStr := fmt.Sprintf("Error storing the upload. Reason: %s\n", err)
 			fmt.Println(errStr)
 			http.Error(w, errStr, http.StatusInternalServerError)
 			return
@@ -806,15 +778,7 @@ func (c *UploadController) Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clothing, err := c.GetClothing("null", true)
-	if err != nil {
-		fmt.Println("Error getting clothing:", err)
-		return
-	}
-	c.CachedClothing = clothing.([]*ClothingItem)
-	for _, item := range c.CachedClothing {
-		c.CachedHashClothing[item.Hash] = item
-	}
+	response.Presets = c.presetUrls(stored.collection(), stored.Name, stored.Presets)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
@@ -825,15 +789,6 @@ func (c *UploadController) UploadBuffer(w http.ResponseWriter, r *http.Request)
 	// max total size 20mb
 	r.Body = http.MaxBytesReader(w, r.Body, 20<<20)
 
-	secret := r.Header.Get("Secret")
-
-	if secret != c.Config.App.Secret {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	fmt.Printf("Body: %s\n", r.Body)
-
 	fileName := r.Header.Get("FileName")
 
 	ext := strings.ToLower(filepath.Ext(fileName))
@@ -845,42 +800,66 @@ func (c *UploadController) UploadBuffer(w http.ResponseWriter, r *http.Request)
 		Url:      utils.JoinURL(c.Config.App.BaseUrl, "static", fileName),
 	}
 
-	// Decode the PNG image
-	img, err := png.Decode(r.Body)
+	var clothing *ClothingFile
+	if clothingFile, ok := clothingFileFromQuery(r.URL.Query(), fileName); ok {
+		clothing = &clothingFile
+	}
+
+	chromaKey, err := c.chromaKeyFromQuery(r.URL.Query())
 	if err != nil {
-		errStr := fmt.Sprintf("Error decoding the PNG file. Reason: %s\n", err)
-		fmt.Println(errStr)
-		http.Error(w, errStr, http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Create the WebP file
-	webpFilePath := utils.JoinURL(c.Config.App.UploadPath, strings.TrimSuffix(fileName, ext)+".webp")
-	webpFile, err := os.Create(webpFilePath)
+	canvas, err := c.canvasFromQuery(r.URL.Query(), clothing)
 	if err != nil {
-		errStr := fmt.Sprintf("Error in creating the WebP file. Reason: %s\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if c.wantsAsync(r) {
+		job, err := c.enqueueUpload(r.Context(), r.Body, -1, uploadJobPayload{
+			BaseName:         strings.TrimSuffix(fileName, ext),
+			RemoveBackground: chromaKey != nil,
+			ChromaKey:        chromaKey,
+			Canvas:           canvas,
+			Clothing:         clothing,
+		})
+		if err != nil {
+			errStr := fmt.Sprintf("Error queueing the upload. Reason: %s\n", err)
+			fmt.Println(errStr)
+			http.Error(w, errStr, http.StatusInternalServerError)
+			return
+		}
+		c.writeJobAccepted(w, fileName, job)
+		return
+	}
+
+	// Decode the PNG image
+	img, err := png.Decode(r.Body)
+	if err != nil {
+		errStr := fmt.Sprintf("Error decoding the PNG file. Reason: %s\n", err)
 		fmt.Println(errStr)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
-	defer webpFile.Close()
-
-	// Encode the image to WebP format and save it
-	var buf bytes.Buffer
-	if err := webp.Encode(&buf, img, nil); err != nil {
-		errStr := fmt.Sprintf("Error encoding the image to WebP format. Reason: %s\n", err)
+	img, err = prepareImage(img, chromaKey, canvas)
+	if err != nil {
+		errStr := fmt.Sprintf("Error processing the image. Reason: %s\n", err)
 		fmt.Println(errStr)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
 
-	if _, err := webpFile.Write(buf.Bytes()); err != nil {
-		errStr := fmt.Sprintf("Error writing the WebP file. Reason: %s\n", err)
+	stored, err := c.storeWebp(r.Context(), strings.TrimSuffix(fileName, ext), img, clothing)
+	if err != nil {
+		errStr := fmt.Sprintf("Error storing the upload. Reason: %s\n", err)
 		fmt.Println(errStr)
 		http.Error(w, errStr, http.StatusInternalServerError)
 		return
 	}
-	response.Url = utils.JoinURL(c.Config.App.BaseUrl, "static", strings.TrimSuffix(fileName, ext)+".webp")
+	response.Url = utils.JoinURL(c.Config.App.BaseUrl, "static", stored.Name)
+	response.Presets = c.presetUrls(stored.collection(), stored.Name, stored.Presets)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
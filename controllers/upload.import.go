@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"lorraxs/fivem_cdn_server/utils"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// maxImportSize caps the whole request body, maxImportEntrySize a single
+// file inside the archive.
+const (
+	maxImportSize      = 200 << 20
+	maxImportEntrySize = 50 << 20
+)
+
+type ImportResult struct {
+	File     string `json:"file"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	FileName string `json:"fileName,omitempty"`
+	Url      string `json:"url,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+type ImportResponse struct {
+	Success  bool           `json:"success"`
+	Total    int            `json:"total"`
+	Imported int            `json:"imported"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+type importEntry struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// ImportCollection accepts a zip, tar or tar.gz archive of clothing images
+// named collection-gender-componentType-componentId-drawableId-textureId and
+// stores every entry like /upload would. Entries are stored under their base
+// name, a second entry with the same base name fails instead of overwriting
+// the first.
+func (c *UploadController) ImportCollection(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	r.ParseMultipartForm(maxImportSize)
+	chromaKey, err := c.chromaKeyFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body io.Reader = r.Body
+	var size int64 = -1
+	if f, h, err := r.FormFile("file"); err == nil {
+		defer f.Close()
+		body, size = f, h.Size
+	}
+
+	response := ImportResponse{Success: true, Results: []ImportResult{}}
+	seen := map[string]string{}
+	err = walkArchive(body, size, func(entry importEntry) {
+		var result ImportResult
+		base := path.Base(entry.name)
+		if first, ok := seen[base]; ok {
+			result = ImportResult{File: entry.name, Error: fmt.Sprintf("duplicate file name, %s has the same name", first)}
+		} else {
+			seen[base] = entry.name
+			result = c.importEntry(r.Context(), entry, chromaKey, r.URL.Query())
+		}
+		response.Total++
+		if result.Success {
+			response.Imported++
+		} else {
+			response.Failed++
+		}
+		response.Results = append(response.Results, result)
+	})
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Archive is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		errStr := fmt.Sprintf("Error reading the archive. Reason: %s\n", err)
+		fmt.Println(errStr)
+		http.Error(w, errStr, http.StatusBadRequest)
+		return
+	}
+	response.Success = response.Failed == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *UploadController) importEntry(ctx context.Context, entry importEntry, chromaKey *utils.ChromaKeyOptions, query url.Values) ImportResult {
+	result := ImportResult{File: entry.name}
+	fail := func(err error) ImportResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	base := path.Base(entry.name)
+	ext := strings.ToLower(path.Ext(base))
+	clothing, ok := parseClothingFileName(base)
+	if !ok {
+		return fail(errors.New("name does not follow collection-gender-componentType-componentId-drawableId-textureId"))
+	}
+
+	rc, err := entry.open()
+	if err != nil {
+		return fail(err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxImportEntrySize+1))
+	if err != nil {
+		return fail(err)
+	}
+	if len(data) > maxImportEntrySize {
+		return fail(errors.New("file is too large"))
+	}
+
+	var stored *StoredFile
+	switch ext {
+	case ".png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fail(fmt.Errorf("decoding the PNG file: %w", err))
+		}
+		canvas, err := c.canvasFromQuery(query, &clothing)
+		if err != nil {
+			return fail(err)
+		}
+		img, err = prepareImage(img, chromaKey, canvas)
+		if err != nil {
+			return fail(err)
+		}
+		stored, err = c.storeWebp(ctx, removedExt(base), img, &clothing)
+		if err != nil {
+			return fail(err)
+		}
+	case ".webp", ".jpg", ".jpeg":
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fail(fmt.Errorf("decoding the image: %w", err))
+		}
+		stored = &StoredFile{Name: base, Size: int64(len(data)), Mime: mime.TypeByExtension(ext), Clothing: &clothing}
+		defer c.holdFile(stored.Name)()
+		if err := c.Storage.Put(ctx, stored.Name, bytes.NewReader(data), stored.Size, stored.Mime); err != nil {
+			return fail(err)
+		}
+		if err := c.finishStore(ctx, stored, img); err != nil {
+			return fail(err)
+		}
+	default:
+		return fail(errors.New("only PNG, JPG, and WebP files are allowed"))
+	}
+
+	result.Success = true
+	result.FileName = stored.Name
+	result.Url = utils.JoinURL(c.Config.App.BaseUrl, "static", stored.Name)
+	result.Hash = clothing.Hash()
+	return result
+}
+
+// walkArchive calls fn for every regular file of a zip, tar or gzipped tar
+// archive, detected from its magic bytes.
+func walkArchive(r io.Reader, size int64, fn func(importEntry)) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		readerAt, ok := r.(io.ReaderAt)
+		if !ok || size < 0 {
+			data, err := io.ReadAll(br)
+			if err != nil {
+				return err
+			}
+			readerAt, size = bytes.NewReader(data), int64(len(data))
+		}
+		zr, err := zip.NewReader(readerAt, size)
+		if err != nil {
+			return err
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || skipArchiveEntry(f.Name) {
+				continue
+			}
+			fn(importEntry{name: f.Name, open: f.Open})
+		}
+		return nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return walkTar(gz, fn)
+	default:
+		return walkTar(br, fn)
+	}
+}
+
+func walkTar(r io.Reader, fn func(importEntry)) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg || skipArchiveEntry(h.Name) {
+			continue
+		}
+		fn(importEntry{name: h.Name, open: func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		}})
+	}
+}
+
+func skipArchiveEntry(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".")
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"lorraxs/fivem_cdn_server/jobs"
+	"lorraxs/fivem_cdn_server/utils"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const uploadJobType = "upload"
+
+type uploadJobPayload struct {
+	Input            string                  `json:"input"`
+	BaseName         string                  `json:"baseName"`
+	RemoveBackground bool                    `json:"removeBackground"`
+	ChromaKey        *utils.ChromaKeyOptions `json:"chromaKey,omitempty"`
+	Canvas           *utils.CanvasOptions    `json:"canvas,omitempty"`
+	Clothing         *ClothingFile           `json:"clothing,omitempty"`
+}
+
+type uploadJobResult struct {
+	FileName string            `json:"fileName"`
+	Url      string            `json:"url"`
+	Presets  map[string]string `json:"presets,omitempty"`
+}
+
+// wantsAsync reports whether an upload should go through the job queue,
+// the async query parameter overrides the configured default.
+func (c *UploadController) wantsAsync(r *http.Request) bool {
+	if v := r.URL.Query().Get("async"); v != "" {
+		return v == "true"
+	}
+	return c.Config.Jobs.AsyncUploads
+}
+
+// enqueueUpload parks the raw PNG in storage and queues its processing.
+func (c *UploadController) enqueueUpload(ctx context.Context, data io.Reader, size int64, payload uploadJobPayload) (*jobs.Job, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	payload.Input = path.Join("jobs", "input", hex.EncodeToString(b)+".png")
+	if err := c.Storage.Put(ctx, payload.Input, data, size, "image/png"); err != nil {
+		return nil, fmt.Errorf("storing the job input: %w", err)
+	}
+	return c.Jobs.Enqueue(ctx, uploadJobType, payload)
+}
+
+func (c *UploadController) writeJobAccepted(w http.ResponseWriter, fileName string, job *jobs.Job) {
+	response := UploadResponse{
+		Success:   true,
+		Message:   "Upload queued",
+		FileName:  fileName,
+		JobId:     job.ID,
+		StatusUrl: utils.JoinURL(c.Config.App.BaseUrl, "jobs", strconv.FormatInt(job.ID, 10)),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *UploadController) runUploadJob(ctx context.Context, job *jobs.Job) (any, error) {
+	var payload uploadJobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, err
+	}
+	result, err := c.processUploadJob(ctx, payload)
+	if err == nil || job.Attempts >= job.MaxAttempts {
+		c.Storage.Delete(ctx, payload.Input)
+	}
+	return result, err
+}
+
+func (c *UploadController) processUploadJob(ctx context.Context, payload uploadJobPayload) (*uploadJobResult, error) {
+	src, err := c.Storage.Get(ctx, payload.Input)
+	if err != nil {
+		return nil, fmt.Errorf("reading the job input: %w", err)
+	}
+	img, err := png.Decode(src)
+	src.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decoding the PNG file: %w", err)
+	}
+	chromaKey := payload.ChromaKey
+	if payload.RemoveBackground && chromaKey == nil {
+		opts := utils.DefaultChromaKeyOptions()
+		chromaKey = &opts
+	}
+	img, err = prepareImage(img, chromaKey, payload.Canvas)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := c.storeWebp(ctx, payload.BaseName, img, payload.Clothing)
+	if err != nil {
+		return nil, err
+	}
+	return &uploadJobResult{
+		FileName: stored.Name,
+		Url:      utils.JoinURL(c.Config.App.BaseUrl, "static", stored.Name),
+		Presets:  c.presetUrls(stored.collection(), stored.Name, stored.Presets),
+	}, nil
+}
+
+func (c *UploadController) GetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+	job, err := c.Jobs.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, jobs.ErrNotFound) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
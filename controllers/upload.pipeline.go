@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"lorraxs/fivem_cdn_server/utils"
+	"lorraxs/fivem_cdn_server/webhooks"
+	"net/url"
+	"strconv"
+)
+
+// StoredFile describes an upload once it has been written to storage.
+type StoredFile struct {
+	Name     string
+	Size     int64
+	Mime     string
+	Presets  []string
+	Clothing *ClothingFile
+	// Perceptual is only computed for clothing textures.
+	Perceptual *utils.PerceptualHash
+}
+
+func (s *StoredFile) collection() string {
+	if s.Clothing == nil {
+		return ""
+	}
+	return s.Clothing.CollectionName
+}
+
+// prepareImage removes the background and places the result on the preview
+// canvas, either step is skipped when its options are nil.
+func prepareImage(img image.Image, chromaKey *utils.ChromaKeyOptions, canvas *utils.CanvasOptions) (image.Image, error) {
+	if chromaKey != nil {
+		var err error
+		img, err = utils.RemoveBackground(img, *chromaKey)
+		if err != nil {
+			return nil, fmt.Errorf("removing the background: %w", err)
+		}
+	}
+	if canvas != nil {
+		img = utils.Recanvas(img, *canvas)
+	}
+	return img, nil
+}
+
+// canvasFromQuery resolves the preview canvas from the componentType profile
+// in config, canvas=true|false toggles it and aspect, padding and anchor
+// override the profile. nil means the image keeps its trimmed size.
+func (c *UploadController) canvasFromQuery(query url.Values, clothing *ClothingFile) (*utils.CanvasOptions, error) {
+	enabled := c.Config.Canvas.Enabled
+	if v := query.Get("canvas"); v != "" {
+		enabled = v == "true"
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	opts := utils.CanvasOptions{AspectWidth: 1, AspectHeight: 1, Anchor: utils.AnchorCenter}
+	componentType := ""
+	if clothing != nil {
+		componentType = clothing.ComponentType
+	}
+	if profile, ok := c.Config.Canvas.Profile(componentType); ok {
+		opts.AspectWidth, opts.AspectHeight = profile.AspectWidth, profile.AspectHeight
+		opts.Padding = profile.Padding
+		if anchor, ok := utils.ParseAnchor(profile.Anchor); ok {
+			opts.Anchor = anchor
+		}
+	}
+
+	if v := query.Get("aspect"); v != "" {
+		w, h, ok := utils.ParseAspect(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid aspect parameter %q", v)
+		}
+		opts.AspectWidth, opts.AspectHeight = w, h
+	}
+	if v := query.Get("padding"); v != "" {
+		padding, err := strconv.ParseFloat(v, 64)
+		if err != nil || padding < 0 || padding > 45 {
+			return nil, fmt.Errorf("invalid padding parameter %q", v)
+		}
+		opts.Padding = padding
+	}
+	if v := query.Get("anchor"); v != "" {
+		anchor, ok := utils.ParseAnchor(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid anchor parameter %q", v)
+		}
+		opts.Anchor = anchor
+	}
+	return &opts, nil
+}
+
+// storeWebp converts img to WebP under baseName and finishes the upload,
+// see finishStore.
+func (c *UploadController) storeWebp(ctx context.Context, baseName string, img image.Image, clothing *ClothingFile) (*StoredFile, error) {
+	stored := &StoredFile{Name: baseName + ".webp", Mime: "image/webp", Clothing: clothing}
+	defer c.holdFile(stored.Name)()
+	size, err := c.putWebp(ctx, stored.Name, img)
+	if err != nil {
+		return nil, fmt.Errorf("writing the WebP file: %w", err)
+	}
+	stored.Size = size
+	return stored, c.finishStore(ctx, stored, img)
+}
+
+// finishStore renders the thumbnail presets of an already stored file and
+// records it in the clothing catalog when it is a clothing texture. Only
+// that item is reloaded into the catalog cache.
+func (c *UploadController) finishStore(ctx context.Context, stored *StoredFile, img image.Image) error {
+	presets, err := c.generatePresets(ctx, removedExt(stored.Name), img)
+	if err != nil {
+		return fmt.Errorf("generating thumbnails: %w", err)
+	}
+	stored.Presets = presets
+	clothingHash := ""
+	if stored.Clothing != nil {
+		clothingHash = stored.Clothing.Hash()
+	}
+	// a re-upload replaces what the CDN may still have cached
+	defer c.purge(c.fileUrls(stored.Name, clothingHash))
+	if stored.Clothing != nil {
+		perceptual := utils.ComputePerceptualHash(img)
+		stored.Perceptual = &perceptual
+		if err := c.SaveClothingItem(ctx, *stored.Clothing, stored.Name, stored.Size, stored.Mime, stored.Presets, stored.Perceptual); err != nil {
+			return fmt.Errorf("saving the clothing item: %w", err)
+		}
+		item, err := c.GetClothingItem(ctx, clothingHash)
+		if err != nil {
+			return fmt.Errorf("loading the clothing item: %w", err)
+		}
+		c.Catalog.Put(item)
+	}
+	c.emit(webhooks.EventTextureUploaded, c.textureEvent(stored))
+	return nil
+}
+
+// reloadCatalog rebuilds the cached catalog from the database and swaps it
+// in, stale entries go away with the old snapshot. Mutations update the
+// cache incrementally, a full reload is only needed on startup and flush.
+func (c *UploadController) reloadCatalog() error {
+	items, err := c.GetClothingItems(c.ctx, "null")
+	if err != nil {
+		return err
+	}
+	c.Catalog.Replace(items)
+	return nil
+}
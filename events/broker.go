@@ -0,0 +1,187 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResetEvent is sent when a client resumes from an id the buffer no longer
+// holds, it has to reload the manifest instead of replaying.
+const ResetEvent = "reset"
+
+type Event struct {
+	ID   uint64          `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Broker fans catalog events out to Server-Sent Events clients and keeps
+// the last events in a ring buffer so a reconnecting client can resume with
+// Last-Event-ID.
+type Broker struct {
+	lock        sync.Mutex
+	ring        []Event
+	next        int
+	count       int
+	lastID      uint64
+	subscribers map[chan Event]struct{}
+	heartbeat   time.Duration
+}
+
+func NewBroker(size int, heartbeat time.Duration) *Broker {
+	return &Broker{
+		ring:        make([]Event, size),
+		subscribers: make(map[chan Event]struct{}),
+		heartbeat:   heartbeat,
+	}
+}
+
+func (b *Broker) Publish(eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Data: payload}
+	b.ring[b.next] = event
+	b.next = (b.next + 1) % len(b.ring)
+	if b.count < len(b.ring) {
+		b.count++
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// too slow to keep up, it resumes from its Last-Event-ID
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// subscribe registers a client and returns the newest id. A resuming
+// client also gets the buffered events after lastID, ok is false when lastID
+// is no longer (or never was) buffered. A new client starts from the newest
+// id without a replay.
+func (b *Broker) subscribe(lastID uint64, resume bool) (replay []Event, ch chan Event, current uint64, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	ch = make(chan Event, 64)
+	b.subscribers[ch] = struct{}{}
+	current = b.lastID
+	if !resume {
+		return nil, ch, current, true
+	}
+
+	oldest := b.lastID - uint64(b.count) + 1
+	if lastID > b.lastID || (b.count > 0 && lastID+1 < oldest) || (b.count == 0 && lastID != b.lastID) {
+		return nil, ch, current, false
+	}
+	start := (b.next - b.count + len(b.ring)) % len(b.ring)
+	for i := 0; i < b.count; i++ {
+		event := b.ring[(start+i)%len(b.ring)]
+		if event.ID > lastID {
+			replay = append(replay, event)
+		}
+	}
+	return replay, ch, current, true
+}
+
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
+
+// ServeHTTP streams events as text/event-stream. The types query parameter
+// takes a comma separated list of event types to receive, Last-Event-ID
+// (header or lastEventId query parameter) resumes a previous stream.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	types := map[string]bool{}
+	for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types[t] = true
+		}
+	}
+	wanted := func(event Event) bool {
+		return len(types) == 0 || types[event.Type]
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+	resume := lastID != ""
+	from, err := strconv.ParseUint(lastID, 10, 64)
+	if resume && err != nil {
+		http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	replay, ch, current, ok := b.subscribe(from, resume)
+	defer b.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if resume && !ok {
+		writeEvent(w, Event{ID: current, Type: ResetEvent, Data: json.RawMessage("{}")})
+	} else if !resume {
+		fmt.Fprint(w, ": connected\n\n")
+	}
+	for _, event := range replay {
+		if wanted(event) {
+			writeEvent(w, event)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(b.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-ch:
+			if !open {
+				return
+			}
+			if !wanted(event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
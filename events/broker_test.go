@@ -0,0 +1,39 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeWithoutResumeSkipsTheBuffer(t *testing.T) {
+	b := NewBroker(4, time.Minute)
+	for i := 0; i < 3; i++ {
+		b.Publish("texture.uploaded", i)
+	}
+	replay, ch, current, ok := b.subscribe(0, false)
+	defer b.unsubscribe(ch)
+	if !ok || len(replay) != 0 || current != 3 {
+		t.Fatalf("got replay %v, current %d, ok %v, want no replay from 3", replay, current, ok)
+	}
+	b.Publish("texture.uploaded", 3)
+	if event := <-ch; event.ID != 4 {
+		t.Fatalf("got event %d, want 4", event.ID)
+	}
+}
+
+func TestSubscribeResume(t *testing.T) {
+	b := NewBroker(4, time.Minute)
+	for i := 0; i < 6; i++ {
+		b.Publish("texture.uploaded", i)
+	}
+	replay, ch, _, ok := b.subscribe(4, true)
+	b.unsubscribe(ch)
+	if !ok || len(replay) != 2 || replay[0].ID != 5 || replay[1].ID != 6 {
+		t.Fatalf("got replay %v, ok %v, want 5 and 6", replay, ok)
+	}
+	_, ch, _, ok = b.subscribe(1, true)
+	b.unsubscribe(ch)
+	if ok {
+		t.Fatal("resuming from an id that left the buffer should fail")
+	}
+}
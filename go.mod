@@ -3,16 +3,18 @@ module lorraxs/fivem_cdn_server
 go 1.22.3
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/charmbracelet/log v0.4.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/go-sql-driver/mysql v1.9.2
 	github.com/gorilla/mux v1.8.1
+	golang.org/x/image v0.18.0
 )
 
 require (
 	filippo.io/edwards25519 v1.1.0 // indirect
 	github.com/felixge/httpsnoop v1.0.3 // indirect
 	github.com/h2non/bimg v1.1.9 // indirect
-	github.com/mazen160/go-random v0.0.0-20210308102632-d2b501c85c03 // indirect
 )
 
 require (
@@ -0,0 +1,329 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+var ErrNotFound = errors.New("jobs: job not found")
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	Status      string          `json:"status"`
+	Payload     json.RawMessage `json:"-"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Handler runs one job. The returned result is stored as JSON, an error
+// requeues the job until it runs out of attempts.
+type Handler func(ctx context.Context, job *Job) (any, error)
+
+// Queue is a MySQL backed job queue worked by a pool of goroutines, in one
+// or several processes. A claimed job records the instance running it and a
+// heartbeat that is refreshed while it runs. Jobs survive restarts and
+// crashes: a running job whose heartbeat went stale is queued again.
+type Queue struct {
+	db                *sql.DB
+	instance          string
+	workers           int
+	maxAttempts       int
+	pollInterval      time.Duration
+	heartbeatInterval time.Duration
+	lock              sync.RWMutex
+	handlers          map[string]Handler
+	notify            chan struct{}
+}
+
+func NewQueue(db *sql.DB, workers int, maxAttempts int, pollInterval, heartbeatInterval time.Duration) *Queue {
+	return &Queue{
+		db:                db,
+		instance:          instanceId(),
+		workers:           workers,
+		maxAttempts:       maxAttempts,
+		pollInterval:      pollInterval,
+		heartbeatInterval: heartbeatInterval,
+		handlers:          make(map[string]Handler),
+		notify:            make(chan struct{}, 1),
+	}
+}
+
+// instanceId names this process in the locked_by column.
+func instanceId() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	id := fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+	if len(id) > 64 {
+		id = id[len(id)-64:]
+	}
+	return id
+}
+
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.handlers[jobType] = handler
+}
+
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload any) (*Job, error) {
+	return q.EnqueueWithAttempts(ctx, jobType, payload, q.maxAttempts)
+}
+
+// EnqueueWithAttempts is Enqueue with a retry budget other than the queue
+// default.
+func (q *Queue) EnqueueWithAttempts(ctx context.Context, jobType string, payload any, maxAttempts int) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	res, err := q.db.ExecContext(ctx, "INSERT INTO jobs (type, status, payload, max_attempts) VALUES (?, ?, ?, ?)",
+		jobType, StatusQueued, string(data), maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return q.Get(ctx, id)
+}
+
+const jobColumns = "id, type, status, payload, result, error, attempts, max_attempts, created_at, started_at, finished_at"
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var payload string
+	var result, jobError sql.NullString
+	var startedAt, finishedAt sql.NullTime
+	err := row.Scan(&job.ID, &job.Type, &job.Status, &payload, &result, &jobError, &job.Attempts, &job.MaxAttempts, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = json.RawMessage(payload)
+	if result.Valid && result.String != "" {
+		job.Result = json.RawMessage(result.String)
+	}
+	job.Error = jobError.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+func (q *Queue) Get(ctx context.Context, id int64) (*Job, error) {
+	job, err := scanJob(q.db.QueryRowContext(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// Start requeues jobs interrupted by a crash or shutdown and starts the
+// workers, along with a reaper that keeps requeueing jobs whose worker
+// stopped sending heartbeats. They stop when ctx is cancelled.
+func (q *Queue) Start(ctx context.Context) error {
+	if err := q.requeueStale(ctx); err != nil {
+		return err
+	}
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+	go q.reap(ctx)
+	return nil
+}
+
+// staleAfter is how old the heartbeat of a running job gets before the job
+// is considered abandoned.
+func (q *Queue) staleAfter() time.Duration {
+	return 3 * q.heartbeatInterval
+}
+
+// requeueStale queues the running jobs with a stale heartbeat again. Jobs
+// other instances are still running keep theirs fresh and are left alone.
+func (q *Queue) requeueStale(ctx context.Context) error {
+	res, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, locked_by = NULL, heartbeat_at = NULL
+		WHERE status = ? AND (heartbeat_at IS NULL OR heartbeat_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND)`,
+		StatusQueued, StatusRunning, int64(q.staleAfter()/time.Second))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		fmt.Printf("Requeued %d abandoned jobs\n", n)
+		select {
+		case q.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *Queue) reap(ctx context.Context) {
+	ticker := time.NewTicker(q.staleAfter())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.requeueStale(ctx); err != nil {
+				fmt.Println("Error requeueing abandoned jobs:", err)
+			}
+		}
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, err := q.claim(ctx)
+			if err != nil {
+				fmt.Println("Error claiming job:", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			q.run(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim picks the oldest runnable job. The conditional update makes sure a
+// job is only ever taken by one worker, even across processes.
+func (q *Queue) claim(ctx context.Context) (*Job, error) {
+	for {
+		var id int64
+		err := q.db.QueryRowContext(ctx, "SELECT id FROM jobs WHERE status = ? AND run_after <= CURRENT_TIMESTAMP ORDER BY id LIMIT 1", StatusQueued).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res, err := q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, attempts = attempts + 1, started_at = CURRENT_TIMESTAMP,
+			locked_by = ?, heartbeat_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+			StatusRunning, q.instance, id, StatusQueued)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return q.Get(ctx, id)
+		}
+	}
+}
+
+// heartbeat refreshes the heartbeat of job until stop is closed.
+func (q *Queue) heartbeat(ctx context.Context, job *Job, stop <-chan struct{}) {
+	ticker := time.NewTicker(q.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := q.db.ExecContext(ctx, "UPDATE jobs SET heartbeat_at = CURRENT_TIMESTAMP WHERE id = ? AND locked_by = ?", job.ID, q.instance)
+			if err != nil {
+				fmt.Printf("Error refreshing the heartbeat of job %d: %s\n", job.ID, err)
+			}
+		}
+	}
+}
+
+// run handles job and records the outcome. The final updates only apply
+// while this instance still holds the job, one that was taken over after a
+// stale heartbeat belongs to its new worker.
+func (q *Queue) run(ctx context.Context, job *Job) {
+	stop := make(chan struct{})
+	go q.heartbeat(ctx, job, stop)
+	defer close(stop)
+
+	q.lock.RLock()
+	handler, ok := q.handlers[job.Type]
+	q.lock.RUnlock()
+
+	var result any
+	var err error
+	if !ok {
+		err = fmt.Errorf("jobs: no handler for %q", job.Type)
+	} else {
+		result, err = q.safeRun(ctx, handler, job)
+	}
+
+	if err == nil {
+		data, _ := json.Marshal(result)
+		_, err = q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, result = ?, error = NULL, finished_at = CURRENT_TIMESTAMP,
+			locked_by = NULL, heartbeat_at = NULL WHERE id = ? AND locked_by = ?`,
+			StatusDone, string(data), job.ID, q.instance)
+		if err != nil {
+			fmt.Println("Error completing job:", err)
+		}
+		return
+	}
+
+	fmt.Printf("Job %d (%s) failed, attempt %d/%d: %s\n", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+	if job.Attempts >= job.MaxAttempts || !ok {
+		_, err = q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP,
+			locked_by = NULL, heartbeat_at = NULL WHERE id = ? AND locked_by = ?`,
+			StatusFailed, err.Error(), job.ID, q.instance)
+	} else {
+		// quadratic backoff: 5s, 20s, 45s...
+		backoff := job.Attempts * job.Attempts * 5
+		_, err = q.db.ExecContext(ctx, `UPDATE jobs SET status = ?, error = ?, run_after = CURRENT_TIMESTAMP + INTERVAL ? SECOND,
+			locked_by = NULL, heartbeat_at = NULL WHERE id = ? AND locked_by = ?`,
+			StatusQueued, err.Error(), backoff, job.ID, q.instance)
+	}
+	if err != nil {
+		fmt.Println("Error updating job:", err)
+	}
+}
+
+func (q *Queue) safeRun(ctx context.Context, handler Handler, job *Job) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("jobs: handler panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
@@ -0,0 +1,97 @@
+package loot
+
+import (
+	"math/rand/v2"
+)
+
+// TierOdds is the chance of a roll landing in a tier.
+type TierOdds struct {
+	Tier   string  `json:"tier"`
+	Chance float64 `json:"chance"`
+}
+
+// ItemOdds is the chance of a roll dropping an item.
+type ItemOdds struct {
+	Item   Item
+	Tier   string
+	Weight float64
+	Chance float64
+}
+
+// Odds lists the exact chances of the next roll, for tiers and items, when
+// something drops at rate percent and pity is as given. Items that can not
+// drop are not listed.
+type Odds struct {
+	// Nothing is the chance that the rate check fails and nothing drops.
+	Nothing float64
+	Tiers   []TierOdds
+	Items   []ItemOdds
+}
+
+// Hit reports whether a roll with a drop rate of rate percent drops
+// anything at all.
+func Hit(rng *rand.Rand, rate float64) bool {
+	return rng.Float64()*100 < rate
+}
+
+func clampRate(rate float64) float64 {
+	return min(max(rate, 0), 100) / 100
+}
+
+// ExactOdds computes the chances from the same drop rates, weights and
+// pity adjustments that Roll uses. A roll guaranteed by hard pity skips the
+// rate check.
+func (p *Pool) ExactOdds(rate float64, pity Pity) Odds {
+	hit := clampRate(rate)
+	if p.Guaranteed(pity) {
+		hit = 1
+	}
+	odds := Odds{Nothing: 1 - hit, Tiers: []TierOdds{}, Items: []ItemOdds{}}
+	if p.Empty() {
+		odds.Nothing = 1
+	}
+	for i, chance := range p.Odds(pity) {
+		t := p.Tiers[i]
+		odds.Tiers = append(odds.Tiers, TierOdds{Tier: t.Name, Chance: hit * chance})
+		for _, entry := range t.Entries {
+			odds.Items = append(odds.Items, ItemOdds{
+				Item:   entry.Item,
+				Tier:   t.Name,
+				Weight: entry.Weight,
+				Chance: hit * chance * entry.Weight / t.TotalWeight,
+			})
+		}
+	}
+	return odds
+}
+
+// Simulation counts the outcomes of independent rolls at a fixed pity.
+type Simulation struct {
+	Rolls   int
+	Nothing int
+	Tiers   map[string]int
+	Items   map[string]int
+}
+
+// Simulate rolls n times the way the random endpoint does, without
+// advancing pity between rolls, so the counts converge on ExactOdds. The
+// pity adjusted odds are worked out once for all rolls.
+func (p *Pool) Simulate(rng *rand.Rand, rate float64, pity Pity, n int) Simulation {
+	sim := Simulation{Rolls: n, Tiers: map[string]int{}, Items: map[string]int{}}
+	odds := p.Odds(pity)
+	guaranteed := p.Guaranteed(pity)
+	for k := 0; k < n; k++ {
+		if !guaranteed && !Hit(rng, rate) {
+			sim.Nothing++
+			continue
+		}
+		i, j := p.rollWith(rng, odds)
+		if i < 0 {
+			sim.Nothing++
+			continue
+		}
+		sim.Tiers[p.Tiers[i].Name]++
+		sim.Items[p.Tiers[i].Entries[j].Item.Hash]++
+	}
+	return sim
+}
@@ -0,0 +1,55 @@
+package loot
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestExactOddsMatchSimulation(t *testing.T) {
+	table := &Table{
+		Name: "test",
+		Mode: ModeInversePrice,
+		Tiers: []Tier{
+			{Name: "common", DropRate: 80, MaxPrice: 100},
+			{Name: "rare", DropRate: 20, MinPrice: 100, SoftPity: 3, SoftPityStep: 0.1, HardPity: 10},
+		},
+	}
+	pool := NewPool(table, []Item{{"a", 10}, {"b", 20}, {"c", 50}, {"d", 200}, {"e", 400}})
+	const n = 400000
+	for _, pity := range []Pity{nil, {"rare": 4}, {"rare": 9}} {
+		odds := pool.ExactOdds(60, pity)
+		sim := pool.Simulate(rand.New(rand.NewPCG(1, 2)), 60, pity, n)
+		// a share of n rolls is within 5 standard deviations of its chance
+		check := func(what string, chance float64, count int) {
+			t.Helper()
+			tolerance := 5 * math.Sqrt(chance*(1-chance)/n)
+			if got := float64(count) / n; math.Abs(got-chance) > tolerance+1e-9 {
+				t.Errorf("pity %v, %s: observed %.5f, exact %.5f", pity, what, got, chance)
+			}
+		}
+		check("nothing", odds.Nothing, sim.Nothing)
+		for _, tier := range odds.Tiers {
+			check(tier.Tier, tier.Chance, sim.Tiers[tier.Tier])
+		}
+		for _, item := range odds.Items {
+			check(item.Item.Hash, item.Chance, sim.Items[item.Item.Hash])
+		}
+	}
+}
+
+func TestExactOddsHardPitySkipsTheRate(t *testing.T) {
+	table := &Table{
+		Name: "test",
+		Mode: ModeUniform,
+		Tiers: []Tier{
+			{Name: "common", DropRate: 90, MaxPrice: 100},
+			{Name: "rare", DropRate: 10, MinPrice: 100, HardPity: 5},
+		},
+	}
+	pool := NewPool(table, []Item{{"a", 10}, {"b", 200}})
+	odds := pool.ExactOdds(10, Pity{"rare": 4})
+	if odds.Nothing != 0 || odds.Tiers[1].Chance != 1 {
+		t.Fatalf("got %+v, want the rare tier for certain", odds)
+	}
+}
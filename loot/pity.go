@@ -0,0 +1,299 @@
+package loot
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+)
+
+// Pity maps a tier name to the number of rolls a player has gone without
+// hitting it.
+type Pity map[string]int
+
+// PityState is where a player stands with one tier after a roll.
+type PityState struct {
+	Tier     string `json:"tier"`
+	Misses   int    `json:"misses"`
+	SoftPity int    `json:"softPity,omitempty"`
+	HardPity int    `json:"hardPity,omitempty"`
+	// GuaranteedIn is the number of rolls up to and including the one that
+	// hard pity guarantees, 0 when the tier has no hard pity.
+	GuaranteedIn int `json:"guaranteedIn,omitempty"`
+	// Chance is the probability that the next roll lands in the tier.
+	Chance float64 `json:"chance"`
+}
+
+// RollResult is a roll made for a player.
+type RollResult struct {
+	// Missed is set when the rate check failed and nothing dropped, Item
+	// and Tier are then empty.
+	Missed bool
+	Item   Item
+	Tier   string
+	// Pity is set when pity raised the chance of the tier that was hit.
+	Pity  bool
+	State []PityState
+}
+
+// Odds returns the chance of each pool tier for the next roll given pity,
+// in the order of p.Tiers. Without pity the chances follow the drop rates.
+// A tier at hard pity is certain, the rarest one wins if there are several.
+// Soft pity adds to the chance of its tier and the remaining tiers share
+// what is left in proportion to their drop rates.
+func (p *Pool) Odds(pity Pity) []float64 {
+	odds := make([]float64, len(p.Tiers))
+	total := 0.0
+	for _, t := range p.Tiers {
+		total += t.DropRate
+	}
+	if total <= 0 {
+		return odds
+	}
+	base := make([]float64, len(p.Tiers))
+	for i, t := range p.Tiers {
+		base[i] = t.DropRate / total
+	}
+
+	if hard := p.hardPity(pity, base); hard >= 0 {
+		odds[hard] = 1
+		return odds
+	}
+
+	boosted := make([]bool, len(p.Tiers))
+	boostedTotal, rest := 0.0, 0.0
+	for i, t := range p.Tiers {
+		if t.SoftPity > 0 && t.SoftPityStep > 0 && pity[t.Name]+1 >= t.SoftPity {
+			boosted[i] = true
+			odds[i] = min(1, base[i]+t.SoftPityStep*float64(pity[t.Name]+2-t.SoftPity))
+			boostedTotal += odds[i]
+		} else {
+			rest += base[i]
+		}
+	}
+	for i := range p.Tiers {
+		switch {
+		case boosted[i] && (boostedTotal >= 1 || rest <= 0):
+			odds[i] /= boostedTotal
+		case boosted[i]:
+		case boostedTotal >= 1:
+			odds[i] = 0
+		default:
+			odds[i] = base[i] * (1 - boostedTotal) / rest
+		}
+	}
+	return odds
+}
+
+// hardPity returns the index of the tier the next roll is guaranteed to hit,
+// or -1. base holds the chances of the tiers without pity.
+func (p *Pool) hardPity(pity Pity, base []float64) int {
+	hard := -1
+	for i, t := range p.Tiers {
+		if t.HardPity > 0 && pity[t.Name]+1 >= t.HardPity && (hard < 0 || base[i] < base[hard]) {
+			hard = i
+		}
+	}
+	return hard
+}
+
+// Guaranteed reports whether hard pity guarantees the next roll a drop,
+// whatever the rate.
+func (p *Pool) Guaranteed(pity Pity) bool {
+	for _, t := range p.Tiers {
+		if t.HardPity > 0 && t.DropRate > 0 && pity[t.Name]+1 >= t.HardPity {
+			return true
+		}
+	}
+	return false
+}
+
+// RollWithPity is Roll with the tier chances adjusted for pity.
+func (p *Pool) RollWithPity(rng *rand.Rand, pity Pity) (item Item, tier string, boosted bool, ok bool) {
+	odds := p.Odds(pity)
+	i, j := p.rollWith(rng, odds)
+	if i < 0 {
+		return Item{}, "", false, false
+	}
+	t := p.Tiers[i]
+	boosted = len(pity) > 0 && odds[i] > p.Odds(nil)[i]
+	return t.Entries[j].Item, t.Name, boosted, true
+}
+
+// Advance returns the pity after a roll that hit tier, an empty tier for a
+// roll that dropped nothing. Every other tier of the table with pity counts
+// the roll as a miss, tiers without items in this pool included, so
+// switching components does not reset anything.
+func (p *Pool) Advance(pity Pity, tier string) Pity {
+	next := Pity{}
+	for _, t := range p.Table.Tiers {
+		if !t.hasPity() {
+			continue
+		}
+		if t.Name == tier {
+			next[t.Name] = 0
+		} else {
+			next[t.Name] = pity[t.Name] + 1
+		}
+	}
+	return next
+}
+
+// States describes pity for every tier of the table that has it.
+func (p *Pool) States(pity Pity) []PityState {
+	odds := p.Odds(pity)
+	chances := make(map[string]float64, len(odds))
+	for i, t := range p.Tiers {
+		chances[t.Name] = odds[i]
+	}
+	states := []PityState{}
+	for _, t := range p.Table.Tiers {
+		if !t.hasPity() {
+			continue
+		}
+		state := PityState{
+			Tier:     t.Name,
+			Misses:   pity[t.Name],
+			SoftPity: t.SoftPity,
+			HardPity: t.HardPity,
+			Chance:   chances[t.Name],
+		}
+		if t.HardPity > 0 {
+			state.GuaranteedIn = max(1, t.HardPity-pity[t.Name])
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Pity loads the counters of player for a table.
+func (s *Store) Pity(ctx context.Context, player string, table string) (Pity, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT tier, misses FROM loot_pity WHERE player = ? AND table_name = ?", player, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pity := Pity{}
+	for rows.Next() {
+		var tier string
+		var misses int
+		if err := rows.Scan(&tier, &misses); err != nil {
+			return nil, err
+		}
+		pity[tier] = misses
+	}
+	return pity, rows.Err()
+}
+
+// Session holds the pity counters of one player for one table, locked
+// until it is committed or rolled back, so concurrent rolls of a player are
+// applied one after the other.
+type Session struct {
+	tx     *sql.Tx
+	table  string
+	player string
+	pity   Pity
+}
+
+// Begin locks and loads the pity counters of player for table.
+func (s *Store) Begin(ctx context.Context, table string, player string) (*Session, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT tier, misses FROM loot_pity WHERE player = ? AND table_name = ? FOR UPDATE", player, table)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer rows.Close()
+	session := &Session{tx: tx, table: table, player: player, pity: Pity{}}
+	for rows.Next() {
+		var tier string
+		var misses int
+		if err := rows.Scan(&tier, &misses); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		session.pity[tier] = misses
+	}
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (ss *Session) Pity() Pity {
+	return ss.pity
+}
+
+// Roll rolls pool, which must be built from the session table, with the
+// player's pity and records the roll. Something drops at rate percent,
+// unless hard pity guarantees the roll, and a roll that drops nothing still
+// counts towards pity. ok is false, and nothing is recorded, when the pool
+// is empty.
+func (ss *Session) Roll(ctx context.Context, pool *Pool, rng *rand.Rand, rate float64) (*RollResult, bool, error) {
+	if pool.Empty() {
+		return nil, false, nil
+	}
+	if !pool.Guaranteed(ss.pity) && !Hit(rng, rate) {
+		next := pool.Advance(ss.pity, "")
+		if err := ss.record(ctx, next, nil, nil, false); err != nil {
+			return nil, false, err
+		}
+		return &RollResult{Missed: true, State: pool.States(next)}, true, nil
+	}
+	item, tier, boosted, ok := pool.RollWithPity(rng, ss.pity)
+	if !ok {
+		return nil, false, nil
+	}
+	next := pool.Advance(ss.pity, tier)
+	if err := ss.record(ctx, next, tier, item.Hash, boosted); err != nil {
+		return nil, false, err
+	}
+	return &RollResult{Item: item, Tier: tier, Pity: boosted, State: pool.States(next)}, true, nil
+}
+
+// record stores the counters after a roll and logs the roll, tier and hash
+// are nil for a roll that dropped nothing.
+func (ss *Session) record(ctx context.Context, next Pity, tier any, hash any, boosted bool) error {
+	for name, misses := range next {
+		_, err := ss.tx.ExecContext(ctx, `INSERT INTO loot_pity (player, table_name, tier, misses) VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE misses = VALUES(misses)`, ss.player, ss.table, name, misses)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := ss.tx.ExecContext(ctx, "INSERT INTO loot_rolls (player, table_name, tier, hash, pity) VALUES (?, ?, ?, ?, ?)",
+		ss.player, ss.table, tier, hash, boosted)
+	if err != nil {
+		return err
+	}
+	ss.pity = next
+	return nil
+}
+
+func (ss *Session) Commit() error {
+	return ss.tx.Commit()
+}
+
+// Rollback discards the rolls of the session, it is a no-op once the
+// session is committed.
+func (ss *Session) Rollback() error {
+	return ss.tx.Rollback()
+}
+
+// RollFor rolls pool once for player in a session of its own, see
+// Session.Roll.
+func (s *Store) RollFor(ctx context.Context, pool *Pool, player string, rng *rand.Rand, rate float64) (*RollResult, bool, error) {
+	session, err := s.Begin(ctx, pool.Table.Name, player)
+	if err != nil {
+		return nil, false, err
+	}
+	defer session.Rollback()
+	result, ok, err := session.Roll(ctx, pool, rng, rate)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return result, true, session.Commit()
+}
@@ -0,0 +1,171 @@
+package loot
+
+import (
+	crand "crypto/rand"
+	"math"
+	"math/rand/v2"
+)
+
+// Item is a droppable catalog item.
+type Item struct {
+	Hash  string
+	Price float64
+}
+
+type Entry struct {
+	Item   Item
+	Weight float64
+}
+
+// PoolTier is a tier with the items that landed in it.
+type PoolTier struct {
+	Tier
+	Entries     []Entry
+	TotalWeight float64
+	weights     []float64
+}
+
+// Pool is a table applied to a set of items, ready to roll. Only tiers with
+// at least one item of positive weight are kept.
+type Pool struct {
+	Table *Table
+	Tiers []PoolTier
+}
+
+// weight is the weight of an item under mode. Items without a price have
+// no weight, they only drop when an override gives them one.
+func weight(mode string, price float64) float64 {
+	if price <= 0 {
+		return 0
+	}
+	switch mode {
+	case ModeInversePrice:
+		return 1 / price
+	case ModeInverseSqrtPrice:
+		return 1 / math.Sqrt(price)
+	default:
+		return 1
+	}
+}
+
+// NewPool sorts items into the tiers of t and weighs them. A table without
+// tiers behaves as a single tier with a drop rate of 1 holding every item.
+func NewPool(t *Table, items []Item) *Pool {
+	tiers := t.Tiers
+	if len(tiers) == 0 {
+		tiers = []Tier{{Name: "default", DropRate: 1}}
+	}
+	overrides := make(map[string]Override, len(t.Overrides))
+	for _, override := range t.Overrides {
+		overrides[override.Hash] = override
+	}
+
+	poolTiers := make([]PoolTier, len(tiers))
+	for i, tier := range tiers {
+		poolTiers[i].Tier = tier
+	}
+	for _, item := range items {
+		w := weight(t.Mode, item.Price)
+		tierIndex := -1
+		override, ok := overrides[item.Hash]
+		if ok && override.Weight != nil {
+			w = *override.Weight
+		}
+		if ok && override.Tier != "" {
+			for i, tier := range tiers {
+				if tier.Name == override.Tier {
+					tierIndex = i
+					break
+				}
+			}
+		} else if len(t.Tiers) == 0 {
+			tierIndex = 0
+		} else {
+			for i, tier := range tiers {
+				if tier.contains(item.Price) {
+					tierIndex = i
+					break
+				}
+			}
+		}
+		if tierIndex < 0 || !(w > 0) {
+			continue
+		}
+		poolTiers[tierIndex].Entries = append(poolTiers[tierIndex].Entries, Entry{Item: item, Weight: w})
+		poolTiers[tierIndex].weights = append(poolTiers[tierIndex].weights, w)
+		poolTiers[tierIndex].TotalWeight += w
+	}
+
+	pool := &Pool{Table: t, Tiers: []PoolTier{}}
+	for _, tier := range poolTiers {
+		if tier.TotalWeight > 0 && tier.DropRate > 0 {
+			pool.Tiers = append(pool.Tiers, tier)
+		}
+	}
+	return pool
+}
+
+func (p *Pool) Empty() bool {
+	return len(p.Tiers) == 0
+}
+
+// Roll picks a tier by drop rate and then an item inside it by weight. ok
+// is false when the pool is empty.
+func (p *Pool) Roll(rng *rand.Rand) (item Item, tier string, ok bool) {
+	item, tier, _, ok = p.RollWithPity(rng, nil)
+	return item, tier, ok
+}
+
+// rollWith picks a tier by odds and an item inside it by weight, it returns
+// the tier and entry index or -1 when nothing can be picked.
+func (p *Pool) rollWith(rng *rand.Rand, odds []float64) (int, int) {
+	i := pick(rng, odds)
+	if i < 0 {
+		return -1, -1
+	}
+	j := pick(rng, p.Tiers[i].weights)
+	if j < 0 {
+		return -1, -1
+	}
+	return i, j
+}
+
+// pick returns an index with a probability proportional to its weight, or
+// -1 when no weight is positive.
+func pick(rng *rand.Rand, weights []float64) int {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	if !(total > 0) {
+		return -1
+	}
+	r := rng.Float64() * total
+	last := -1
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		last = i
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	// rounding can leave r just above the last weight
+	return last
+}
+
+// NewRand returns the generator for one roll. A seed makes the roll
+// deterministic, which is meant for tests and reproducing reports, without
+// one it is seeded from crypto/rand.
+func NewRand(seed *uint64) *rand.Rand {
+	if seed != nil {
+		return rand.New(rand.NewPCG(*seed, 0))
+	}
+	var key [32]byte
+	if _, err := crand.Read(key[:]); err != nil {
+		panic(err)
+	}
+	return rand.New(rand.NewChaCha8(key))
+}
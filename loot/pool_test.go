@@ -0,0 +1,124 @@
+package loot
+
+import (
+	"math"
+	"testing"
+)
+
+func seeded(seed uint64) *uint64 {
+	return &seed
+}
+
+// shares rolls pool n times with a seeded generator and returns the share
+// of each item hash.
+func shares(t *testing.T, pool *Pool, n int) map[string]float64 {
+	t.Helper()
+	rng := NewRand(seeded(42))
+	counts := map[string]int{}
+	for i := 0; i < n; i++ {
+		item, _, ok := pool.Roll(rng)
+		if !ok {
+			t.Fatal("roll of a pool with items failed")
+		}
+		counts[item.Hash]++
+	}
+	result := map[string]float64{}
+	for hash, count := range counts {
+		result[hash] = float64(count) / float64(n)
+	}
+	return result
+}
+
+func near(got, want float64) bool {
+	return math.Abs(got-want) < 0.01
+}
+
+func TestSeededRollsRepeat(t *testing.T) {
+	pool := NewPool(DefaultTable("default"), []Item{{"a", 10}, {"b", 20}, {"c", 30}})
+	first, second := NewRand(seeded(7)), NewRand(seeded(7))
+	for i := 0; i < 100; i++ {
+		a, _, _ := pool.Roll(first)
+		b, _, _ := pool.Roll(second)
+		if a != b {
+			t.Fatalf("roll %d: %s and %s differ with the same seed", i, a.Hash, b.Hash)
+		}
+	}
+}
+
+func TestWeightModes(t *testing.T) {
+	items := []Item{{"a", 10}, {"b", 40}, {"free", 0}}
+	for _, tc := range []struct {
+		mode string
+		want map[string]float64
+	}{
+		{ModeUniform, map[string]float64{"a": 0.5, "b": 0.5}},
+		{ModeInversePrice, map[string]float64{"a": 0.8, "b": 0.2}},
+		{ModeInverseSqrtPrice, map[string]float64{"a": 2.0 / 3, "b": 1.0 / 3}},
+	} {
+		pool := NewPool(&Table{Name: "test", Mode: tc.mode}, items)
+		got := shares(t, pool, 100000)
+		if _, ok := got["free"]; ok {
+			t.Errorf("%s: an item without a price dropped", tc.mode)
+		}
+		for hash, want := range tc.want {
+			if !near(got[hash], want) {
+				t.Errorf("%s: %s dropped %.3f of the time, want %.3f", tc.mode, hash, got[hash], want)
+			}
+		}
+	}
+}
+
+func TestTierSelection(t *testing.T) {
+	table := &Table{
+		Name: "test",
+		Mode: ModeUniform,
+		Tiers: []Tier{
+			{Name: "common", DropRate: 3, MaxPrice: 100},
+			{Name: "rare", DropRate: 1, MinPrice: 100, MaxPrice: 1000},
+			{Name: "empty", DropRate: 5, MinPrice: 1000},
+		},
+	}
+	pool := NewPool(table, []Item{{"a", 10}, {"b", 50}, {"c", 500}})
+	if len(pool.Tiers) != 2 {
+		t.Fatalf("got %d tiers, a tier without items should be left out", len(pool.Tiers))
+	}
+	rng := NewRand(seeded(1))
+	counts := map[string]int{}
+	const n = 100000
+	for i := 0; i < n; i++ {
+		item, tier, _ := pool.Roll(rng)
+		if (tier == "common") != (item.Price < 100) {
+			t.Fatalf("%s landed in tier %s", item.Hash, tier)
+		}
+		counts[tier]++
+	}
+	if got := float64(counts["rare"]) / n; !near(got, 0.25) {
+		t.Errorf("rare tier hit %.3f of the time, want 0.25", got)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	zero, ten := 0.0, 10.0
+	table := &Table{
+		Name:  "test",
+		Mode:  ModeUniform,
+		Tiers: []Tier{{Name: "common", DropRate: 1, MaxPrice: 100}, {Name: "rare", DropRate: 1, MinPrice: 100}},
+		Overrides: []Override{
+			{Hash: "heavy", Weight: &ten},
+			{Hash: "banned", Weight: &zero},
+			{Hash: "moved", Tier: "rare"},
+			{Hash: "free", Weight: &ten},
+		},
+	}
+	pool := NewPool(table, []Item{{"a", 10}, {"heavy", 10}, {"banned", 10}, {"moved", 10}, {"b", 500}, {"free", 0}})
+	got := shares(t, pool, 100000)
+	if got["banned"] != 0 {
+		t.Error("an item with a weight of 0 dropped")
+	}
+	// common holds a (1), heavy (10) and free (10), rare holds b and moved
+	for hash, want := range map[string]float64{"a": 0.5 / 21, "heavy": 5.0 / 21, "free": 5.0 / 21, "moved": 0.25, "b": 0.25} {
+		if !near(got[hash], want) {
+			t.Errorf("%s dropped %.3f of the time, want %.3f", hash, got[hash], want)
+		}
+	}
+}
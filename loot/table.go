@@ -0,0 +1,289 @@
+package loot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Weight modes decide how likely an item is within its tier.
+const (
+	// ModeUniform gives every item the same weight.
+	ModeUniform = "uniform"
+	// ModeInversePrice weights items by 1/price, an item twice as expensive
+	// drops half as often.
+	ModeInversePrice = "inverse_price"
+	// ModeInverseSqrtPrice weights items by 1/sqrt(price), a gentler curve
+	// for catalogs with a wide price range.
+	ModeInverseSqrtPrice = "inverse_sqrt_price"
+)
+
+var Modes = []string{ModeUniform, ModeInversePrice, ModeInverseSqrtPrice}
+
+var ErrNotFound = errors.New("loot: table not found")
+
+// Table is a named loot table. A roll first picks a tier by its drop rate,
+// then an item inside the tier by weight.
+type Table struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Mode      string     `json:"mode"`
+	Tiers     []Tier     `json:"tiers"`
+	Overrides []Override `json:"overrides"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Tier is a rarity tier. Items land in the first tier whose price band
+// contains their price, MaxPrice 0 leaves the band open ended. Drop rates
+// are relative to each other, tiers without items are left out of the roll.
+//
+// Pity only applies to rolls made for a player. Once a player has gone
+// SoftPity-1 rolls without the tier, every further roll adds SoftPityStep to
+// its chance, and roll number HardPity is guaranteed to hit it. 0 turns
+// either threshold off.
+type Tier struct {
+	Name         string  `json:"name"`
+	DropRate     float64 `json:"dropRate"`
+	MinPrice     float64 `json:"minPrice"`
+	MaxPrice     float64 `json:"maxPrice"`
+	SoftPity     int     `json:"softPity,omitempty"`
+	SoftPityStep float64 `json:"softPityStep,omitempty"`
+	HardPity     int     `json:"hardPity,omitempty"`
+}
+
+func (t Tier) hasPity() bool {
+	return t.SoftPity > 0 || t.HardPity > 0
+}
+
+func (t Tier) contains(price float64) bool {
+	return price >= t.MinPrice && (t.MaxPrice <= 0 || price < t.MaxPrice)
+}
+
+// Override pins the weight and/or tier of one item by its catalog hash. A
+// nil Weight keeps the weight of the table mode, a weight of 0 takes the
+// item out of the table.
+type Override struct {
+	Hash   string   `json:"hash"`
+	Weight *float64 `json:"weight,omitempty"`
+	Tier   string   `json:"tier,omitempty"`
+}
+
+// DefaultTable is used when no table is stored under the requested name: a
+// single tier holding every priced item, weighted by inverse price.
+func DefaultTable(name string) *Table {
+	return &Table{Name: name, Mode: ModeInversePrice, Tiers: []Tier{}, Overrides: []Override{}}
+}
+
+func (t *Table) Validate() error {
+	if t.Name == "" || len(t.Name) > 64 {
+		return errors.New("name must be 1 to 64 characters")
+	}
+	validMode := false
+	for _, mode := range Modes {
+		validMode = validMode || t.Mode == mode
+	}
+	if !validMode {
+		return fmt.Errorf("unknown mode %q", t.Mode)
+	}
+	tiers := map[string]bool{}
+	for _, tier := range t.Tiers {
+		if tier.Name == "" || tiers[tier.Name] {
+			return fmt.Errorf("tier names must be unique and not empty, got %q", tier.Name)
+		}
+		tiers[tier.Name] = true
+		if !(tier.DropRate >= 0) || math.IsInf(tier.DropRate, 0) {
+			return fmt.Errorf("tier %s: invalid drop rate", tier.Name)
+		}
+		if tier.MinPrice < 0 || tier.MaxPrice < 0 || (tier.MaxPrice > 0 && tier.MaxPrice <= tier.MinPrice) {
+			return fmt.Errorf("tier %s: invalid price band", tier.Name)
+		}
+		if tier.SoftPity < 0 || tier.HardPity < 0 || (tier.SoftPity > 0 && tier.HardPity > 0 && tier.SoftPity >= tier.HardPity) {
+			return fmt.Errorf("tier %s: soft pity must come before hard pity", tier.Name)
+		}
+		if !(tier.SoftPityStep >= 0 && tier.SoftPityStep <= 1) {
+			return fmt.Errorf("tier %s: soft pity step must be between 0 and 1", tier.Name)
+		}
+	}
+	hashes := map[string]bool{}
+	for _, override := range t.Overrides {
+		if override.Hash == "" || hashes[override.Hash] {
+			return fmt.Errorf("override hashes must be unique and not empty, got %q", override.Hash)
+		}
+		hashes[override.Hash] = true
+		if override.Weight != nil && (!(*override.Weight >= 0) || math.IsInf(*override.Weight, 0)) {
+			return fmt.Errorf("override %s: invalid weight", override.Hash)
+		}
+		if override.Tier != "" && !tiers[override.Tier] {
+			return fmt.Errorf("override %s: unknown tier %q", override.Hash, override.Tier)
+		}
+	}
+	return nil
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+func (s *Store) List(ctx context.Context) ([]*Table, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM loot_tables ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	tables := []*Table{}
+	for _, name := range names {
+		table, err := s.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func (s *Store) Get(ctx context.Context, name string) (*Table, error) {
+	table := Table{Tiers: []Tier{}, Overrides: []Override{}}
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, mode, created_at, updated_at FROM loot_tables WHERE name = ?", name).
+		Scan(&table.ID, &table.Name, &table.Mode, &table.CreatedAt, &table.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT name, drop_rate, min_price, max_price, soft_pity, soft_pity_step, hard_pity
+		FROM loot_tiers WHERE table_id = ? ORDER BY position`, table.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tier Tier
+		if err := rows.Scan(&tier.Name, &tier.DropRate, &tier.MinPrice, &tier.MaxPrice, &tier.SoftPity, &tier.SoftPityStep, &tier.HardPity); err != nil {
+			return nil, err
+		}
+		table.Tiers = append(table.Tiers, tier)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.QueryContext(ctx, "SELECT hash, weight, tier FROM loot_overrides WHERE table_id = ? ORDER BY hash", table.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var override Override
+		var weight sql.NullFloat64
+		var tier sql.NullString
+		if err := rows.Scan(&override.Hash, &weight, &tier); err != nil {
+			return nil, err
+		}
+		if weight.Valid {
+			override.Weight = &weight.Float64
+		}
+		override.Tier = tier.String
+		table.Overrides = append(table.Overrides, override)
+	}
+	return &table, rows.Err()
+}
+
+// Save creates or replaces the table under t.Name, tiers and overrides
+// included.
+func (s *Store) Save(ctx context.Context, t *Table) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO loot_tables (name, mode) VALUES (?, ?) ON DUPLICATE KEY UPDATE mode = VALUES(mode), updated_at = CURRENT_TIMESTAMP",
+		t.Name, t.Mode)
+	if err != nil {
+		return err
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM loot_tables WHERE name = ?", t.Name).Scan(&id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM loot_tiers WHERE table_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM loot_overrides WHERE table_id = ?", id); err != nil {
+		return err
+	}
+	for i, tier := range t.Tiers {
+		_, err := tx.ExecContext(ctx, `INSERT INTO loot_tiers (table_id, name, drop_rate, min_price, max_price, soft_pity, soft_pity_step, hard_pity, position)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, tier.Name, tier.DropRate, tier.MinPrice, tier.MaxPrice, tier.SoftPity, tier.SoftPityStep, tier.HardPity, i)
+		if err != nil {
+			return err
+		}
+	}
+	for _, override := range t.Overrides {
+		var tier any
+		if override.Tier != "" {
+			tier = override.Tier
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO loot_overrides (table_id, hash, weight, tier) VALUES (?, ?, ?, ?)",
+			id, override.Hash, override.Weight, tier)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) Delete(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var id int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM loot_tables WHERE name = ?", name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM loot_overrides WHERE table_id = ?",
+		"DELETE FROM loot_tiers WHERE table_id = ?",
+		"DELETE FROM loot_tables WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
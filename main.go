@@ -4,8 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"lorraxs/fivem_cdn_server/auth"
+	"lorraxs/fivem_cdn_server/cdn"
 	"lorraxs/fivem_cdn_server/config"
 	"lorraxs/fivem_cdn_server/controllers"
+	"lorraxs/fivem_cdn_server/events"
+	"lorraxs/fivem_cdn_server/jobs"
+	"lorraxs/fivem_cdn_server/migrations"
+	"lorraxs/fivem_cdn_server/storage"
+	"lorraxs/fivem_cdn_server/webhooks"
 	"net/http"
 	"os"
 	"time"
@@ -18,14 +25,11 @@ import (
 func main() {
 	ctx := context.Background()
 	config := config.GetConfig()
-	if _, err := os.Stat(config.App.UploadPath); os.IsNotExist(err) {
-		err := os.Mkdir(config.App.UploadPath, 0755)
-		if err != nil {
-			log.Error("Error creating upload path: " + err.Error())
-			panic(err)
-		}
+	store, err := storage.New(config)
+	if err != nil {
+		log.Error("Error creating storage: " + err.Error())
+		panic(err)
 	}
-	fmt.Printf("%+v\n", config)
 	router := getRouter()
 	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -36,8 +40,6 @@ func main() {
 	}
 	defer db.Close()
 
-	controllers.NewUploadController().Init(ctx, router, db)
-
 	err = db.Ping()
 	if err != nil {
 		log.Fatal(err)
@@ -49,8 +51,62 @@ func main() {
 
 	fmt.Println("Kết nối MySQL thành công!")
 
+	var blobs *storage.ContentAddressed
+	if config.Storage.ContentAddressed {
+		blobs = storage.NewContentAddressed(store, db)
+		store = blobs
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1:], db, store); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if config.MySQL.AutoMigrate {
+		ran, err := migrations.Up(ctx, db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range ran {
+			log.Info(fmt.Sprintf("Applied migration %04d_%s", m.Version, m.Name))
+		}
+	}
+
+	legacySecret := ""
+	if config.Auth.LegacySecret && config.App.Secret != "" {
+		legacySecret = config.App.Secret
+		log.Warn("The shared Secret header is deprecated and grants every scope, create API keys with the apikey command and set [auth] legacySecret = false")
+	}
+	guard := auth.NewMiddleware(auth.NewStore(db), legacySecret)
+	router.Use(guard.Handler)
+
+	purger, err := cdn.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	queue := jobs.NewQueue(db, config.Jobs.Workers, config.Jobs.MaxAttempts, config.Jobs.PollInterval, config.Jobs.HeartbeatInterval)
+
+	controllers.NewUploadController().Init(ctx, router, controllers.Dependencies{
+		DB:       db,
+		Storage:  store,
+		Auth:     guard,
+		Jobs:     queue,
+		Purger:   purger,
+		Webhooks: webhooks.NewDispatcher(db, queue, config.Webhooks),
+		Events:   events.NewBroker(config.Events.BufferSize, config.Events.Heartbeat),
+	})
+
+	if err := queue.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if blobs != nil {
+		blobs.StartGarbageCollector(ctx, config.Storage.GcInterval, config.Storage.GcGrace)
+	}
+
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf(("%s\n"), r.Header)
 		fmt.Fprint(w, "Hello, World!")
 	})
 
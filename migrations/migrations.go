@@ -0,0 +1,209 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Load returns the embedded migrations ordered by version. Files are named
+// <version>_<name>.up.sql / <version>_<name>.down.sql.
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations: unexpected file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(files, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations: version %d used by %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+	response := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s has no up script", m.Version, m.Name)
+		}
+		response = append(response, *m)
+	}
+	sort.Slice(response, func(i, j int) bool {
+		return response[i].Version < response[j].Version
+	})
+	return response, nil
+}
+
+func ensureTable(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL,
+		name VARCHAR(191) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (version)
+	)`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int]bool, error) {
+	if err := ensureTable(ctx, db); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// Up applies every pending migration in order and returns the ones it ran.
+func Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ran := []Migration{}
+	for _, m := range all {
+		if applied[m.Version] {
+			continue
+		}
+		if err := execScript(ctx, db, m.Up); err != nil {
+			return ran, fmt.Errorf("migrations: up %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// Down reverts the last steps applied migrations, newest first.
+func Down(ctx context.Context, db *sql.DB, steps int) ([]Migration, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	ran := []Migration{}
+	for i := len(all) - 1; i >= 0 && len(ran) < steps; i-- {
+		m := all[i]
+		if !applied[m.Version] {
+			continue
+		}
+		if m.Down == "" {
+			return ran, fmt.Errorf("migrations: %04d_%s has no down script", m.Version, m.Name)
+		}
+		if err := execScript(ctx, db, m.Down); err != nil {
+			return ran, fmt.Errorf("migrations: down %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version); err != nil {
+			return ran, err
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+func GetStatus(ctx context.Context, db *sql.DB) ([]Status, error) {
+	all, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	response := []Status{}
+	for _, m := range all {
+		response = append(response, Status{Migration: m, Applied: applied[m.Version]})
+	}
+	return response, nil
+}
+
+// execScript runs a script statement by statement, the driver does not
+// accept several statements in one Exec unless multiStatements is set.
+// Statements end with a ";" at the end of a line.
+func execScript(ctx context.Context, db *sql.DB, script string) error {
+	for _, stmt := range splitStatements(script) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitStatements(script string) []string {
+	statements := []string{}
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS texture_prices (
+	hash VARCHAR(64) NOT NULL,
+	price DOUBLE NOT NULL DEFAULT 0,
+	PRIMARY KEY (hash)
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS clothing_items (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	collection VARCHAR(191) NOT NULL,
+	gender TINYINT NOT NULL,
+	component_type VARCHAR(32) NOT NULL,
+	component_id INT NOT NULL,
+	drawable_id INT NOT NULL,
+	texture_id INT NOT NULL,
+	hash CHAR(16) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	size INT NOT NULL DEFAULT 0,
+	mime VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY uniq_clothing_items_hash (hash),
+	KEY idx_clothing_items_file_name (file_name),
+	KEY idx_clothing_items_collection (collection),
+	KEY idx_clothing_items_component (component_type, component_id)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	key_id CHAR(12) NOT NULL,
+	name VARCHAR(191) NOT NULL,
+	secret_hash CHAR(64) NOT NULL,
+	scopes VARCHAR(255) NOT NULL DEFAULT '',
+	expires_at TIMESTAMP NULL DEFAULT NULL,
+	revoked_at TIMESTAMP NULL DEFAULT NULL,
+	last_used_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY uniq_api_keys_key_id (key_id)
+);
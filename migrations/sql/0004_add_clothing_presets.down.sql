@@ -0,0 +1 @@
+ALTER TABLE clothing_items DROP COLUMN presets;
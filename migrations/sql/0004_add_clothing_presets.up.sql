@@ -0,0 +1 @@
+ALTER TABLE clothing_items ADD COLUMN presets VARCHAR(255) NOT NULL DEFAULT '' AFTER mime;
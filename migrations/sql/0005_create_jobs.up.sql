@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS jobs (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	type VARCHAR(64) NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'queued',
+	payload TEXT NOT NULL,
+	result TEXT NULL,
+	error TEXT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	max_attempts INT NOT NULL DEFAULT 3,
+	run_after TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	started_at TIMESTAMP NULL DEFAULT NULL,
+	finished_at TIMESTAMP NULL DEFAULT NULL,
+	locked_by VARCHAR(64) NULL DEFAULT NULL,
+	heartbeat_at TIMESTAMP NULL DEFAULT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY idx_jobs_status_run_after (status, run_after)
+);
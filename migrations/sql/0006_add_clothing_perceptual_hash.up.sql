@@ -0,0 +1,3 @@
+ALTER TABLE clothing_items
+	ADD COLUMN phash BIGINT UNSIGNED NULL AFTER presets,
+	ADD COLUMN dhash BIGINT UNSIGNED NULL AFTER phash;
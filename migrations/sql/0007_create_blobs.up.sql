@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS blobs (
+	sha256 CHAR(64) NOT NULL,
+	size BIGINT NOT NULL,
+	content_type VARCHAR(100) NOT NULL DEFAULT '',
+	ref_count INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (sha256),
+	KEY idx_blobs_ref_count (ref_count, updated_at)
+);
+
+CREATE TABLE IF NOT EXISTS blob_refs (
+	name VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+	sha256 CHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (name),
+	KEY idx_blob_refs_sha256 (sha256)
+);
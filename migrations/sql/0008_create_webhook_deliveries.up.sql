@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	event_id CHAR(32) NOT NULL,
+	event_type VARCHAR(64) NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	payload TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INT NOT NULL DEFAULT 0,
+	response_status INT NULL,
+	error TEXT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	delivered_at TIMESTAMP NULL DEFAULT NULL,
+	PRIMARY KEY (id),
+	KEY idx_webhook_deliveries_event (event_type, created_at),
+	KEY idx_webhook_deliveries_status (status, created_at)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS loot_overrides;
+DROP TABLE IF EXISTS loot_tiers;
+DROP TABLE IF EXISTS loot_tables;
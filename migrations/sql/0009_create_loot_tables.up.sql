@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS loot_tables (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	name VARCHAR(64) NOT NULL,
+	mode VARCHAR(32) NOT NULL DEFAULT 'inverse_price',
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY uniq_loot_tables_name (name)
+);
+
+CREATE TABLE IF NOT EXISTS loot_tiers (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	table_id BIGINT UNSIGNED NOT NULL,
+	name VARCHAR(64) NOT NULL,
+	drop_rate DOUBLE NOT NULL DEFAULT 0,
+	min_price DOUBLE NOT NULL DEFAULT 0,
+	max_price DOUBLE NOT NULL DEFAULT 0,
+	position INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (id),
+	UNIQUE KEY uniq_loot_tiers_name (table_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS loot_overrides (
+	table_id BIGINT UNSIGNED NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	weight DOUBLE NULL DEFAULT NULL,
+	tier VARCHAR(64) NULL DEFAULT NULL,
+	PRIMARY KEY (table_id, hash)
+);
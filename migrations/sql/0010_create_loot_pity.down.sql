@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS loot_rolls;
+DROP TABLE IF EXISTS loot_pity;
+
+ALTER TABLE loot_tiers
+	DROP COLUMN hard_pity,
+	DROP COLUMN soft_pity_step,
+	DROP COLUMN soft_pity;
@@ -0,0 +1,25 @@
+ALTER TABLE loot_tiers
+	ADD COLUMN soft_pity INT NOT NULL DEFAULT 0 AFTER max_price,
+	ADD COLUMN soft_pity_step DOUBLE NOT NULL DEFAULT 0 AFTER soft_pity,
+	ADD COLUMN hard_pity INT NOT NULL DEFAULT 0 AFTER soft_pity_step;
+
+CREATE TABLE IF NOT EXISTS loot_pity (
+	player VARCHAR(64) NOT NULL,
+	table_name VARCHAR(64) NOT NULL,
+	tier VARCHAR(64) NOT NULL,
+	misses INT NOT NULL DEFAULT 0,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+	PRIMARY KEY (player, table_name, tier)
+);
+
+CREATE TABLE IF NOT EXISTS loot_rolls (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+	player VARCHAR(64) NOT NULL,
+	table_name VARCHAR(64) NOT NULL,
+	tier VARCHAR(64) NULL,
+	hash VARCHAR(64) NULL,
+	pity BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY idx_loot_rolls_player (player, table_name, created_at)
+);
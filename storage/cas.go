@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ContentAddressed stores every object once under the SHA-256 of its bytes
+// and keeps a name -> blob reference table in MySQL. Blobs are reference
+// counted, CollectGarbage removes the ones nothing points at anymore. Names
+// without a reference fall through to the backend so files written before
+// it was enabled keep working.
+type ContentAddressed struct {
+	backend Storage
+	db      *sql.DB
+}
+
+type blobObject struct {
+	Object
+	info ObjectInfo
+}
+
+func (o *blobObject) Info() ObjectInfo {
+	return o.info
+}
+
+func NewContentAddressed(backend Storage, db *sql.DB) *ContentAddressed {
+	return &ContentAddressed{backend: backend, db: db}
+}
+
+func blobName(sum string) string {
+	return "blobs/" + sum[:2] + "/" + sum
+}
+
+type blobRef struct {
+	sum         string
+	size        int64
+	contentType string
+	modTime     time.Time
+}
+
+func (s *ContentAddressed) ref(ctx context.Context, name string) (*blobRef, error) {
+	var ref blobRef
+	err := s.db.QueryRowContext(ctx, `SELECT r.sha256, b.size, b.content_type, r.updated_at
+		FROM blob_refs r JOIN blobs b ON b.sha256 = r.sha256 WHERE r.name = ?`, name).
+		Scan(&ref.sum, &ref.size, &ref.contentType, &ref.modTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (ref *blobRef) info(name string) ObjectInfo {
+	return ObjectInfo{
+		Name:        name,
+		Size:        ref.size,
+		ModTime:     ref.modTime,
+		ContentType: ref.contentType,
+		ETag:        ref.sum,
+	}
+}
+
+// Put spools r to a temporary file while hashing it, uploads the blob when
+// it is new and points name at it.
+func (s *ContentAddressed) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	// touching the row first keeps the garbage collector away from a blob
+	// that is about to get a reference. The upsert waits on the row lock a
+	// running collection holds, so the blob is either still there or gone
+	// with its row, in which case the Stat below uploads it again.
+	_, err = s.db.ExecContext(ctx, `INSERT INTO blobs (sha256, size, content_type, ref_count) VALUES (?, ?, ?, 0)
+		ON DUPLICATE KEY UPDATE updated_at = CURRENT_TIMESTAMP`, sum, written, contentType)
+	if err != nil {
+		return err
+	}
+	if _, err := s.backend.Stat(ctx, blobName(sum)); errors.Is(err, ErrNotFound) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.backend.Put(ctx, blobName(sum), tmp, written, contentType); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var previous string
+	err = tx.QueryRowContext(ctx, "SELECT sha256 FROM blob_refs WHERE name = ? FOR UPDATE", name).Scan(&previous)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.ExecContext(ctx, "INSERT INTO blob_refs (name, sha256) VALUES (?, ?)", name, sum)
+	case err != nil:
+		return err
+	case previous == sum:
+		_, err = tx.ExecContext(ctx, "UPDATE blob_refs SET updated_at = CURRENT_TIMESTAMP WHERE name = ?", name)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	default:
+		_, err = tx.ExecContext(ctx, "UPDATE blob_refs SET sha256 = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?", sum, name)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count - 1 WHERE sha256 = ?", previous)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count + 1 WHERE sha256 = ?", sum); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// the name now lives in the blob store, drop a legacy copy
+	if err := s.backend.Delete(ctx, name); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (s *ContentAddressed) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.Open(ctx, name)
+}
+
+func (s *ContentAddressed) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	ref, err := s.ref(ctx, name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if ref == nil {
+		return s.backend.Stat(ctx, name)
+	}
+	return ref.info(name), nil
+}
+
+func (s *ContentAddressed) Open(ctx context.Context, name string) (Object, error) {
+	ref, err := s.ref(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return s.backend.Open(ctx, name)
+	}
+	obj, err := s.backend.Open(ctx, blobName(ref.sum))
+	if err != nil {
+		return nil, err
+	}
+	return &blobObject{Object: obj, info: ref.info(name)}, nil
+}
+
+// List merges the referenced names under prefix with the legacy objects of
+// the backend, a reference wins over a legacy file of the same name.
+func (s *ContentAddressed) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	legacy, err := s.backend.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	rows, err := s.db.QueryContext(ctx, `SELECT r.name, r.sha256, b.size, b.content_type, r.updated_at
+		FROM blob_refs r JOIN blobs b ON b.sha256 = r.sha256
+		WHERE r.name LIKE ? ORDER BY r.name`, likePrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	response := []ObjectInfo{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		var name string
+		var ref blobRef
+		if err := rows.Scan(&name, &ref.sum, &ref.size, &ref.contentType, &ref.modTime); err != nil {
+			return nil, err
+		}
+		if strings.Contains(name[len(dir):], "/") {
+			continue
+		}
+		seen[name] = true
+		response = append(response, ref.info(name))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, info := range legacy {
+		if !seen[info.Name] {
+			response = append(response, info)
+		}
+	}
+	return response, nil
+}
+
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	return replacer.Replace(prefix) + "%"
+}
+
+// Delete drops the reference of name and releases its blob, names without
+// a reference are deleted from the backend.
+func (s *ContentAddressed) Delete(ctx context.Context, name string) error {
+	name, err := cleanName(name)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var sum string
+	err = tx.QueryRowContext(ctx, "SELECT sha256 FROM blob_refs WHERE name = ? FOR UPDATE", name).Scan(&sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.backend.Delete(ctx, name)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blob_refs WHERE name = ?", name); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE blobs SET ref_count = ref_count - 1, updated_at = CURRENT_TIMESTAMP WHERE sha256 = ?", sum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CollectGarbage deletes blobs that have had no reference for longer than
+// grace and returns how many were removed.
+func (s *ContentAddressed) CollectGarbage(ctx context.Context, grace time.Duration) (int, error) {
+	seconds := int64(grace / time.Second)
+	rows, err := s.db.QueryContext(ctx, "SELECT sha256 FROM blobs WHERE ref_count <= 0 AND updated_at < NOW() - INTERVAL ? SECOND", seconds)
+	if err != nil {
+		return 0, err
+	}
+	sums := []string{}
+	for rows.Next() {
+		var sum string
+		if err := rows.Scan(&sum); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sums = append(sums, sum)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, sum := range sums {
+		ok, err := s.removeBlob(ctx, sum, seconds)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeBlob deletes the row and the file of sum when it is still
+// unreferenced. The row stays locked until the file is gone, a concurrent
+// Put of the same content waits for the commit and uploads it again.
+func (s *ContentAddressed) removeBlob(ctx context.Context, sum string, seconds int64) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// a Put may have picked the blob up again since the select
+	var stale bool
+	err = tx.QueryRowContext(ctx, "SELECT ref_count <= 0 AND updated_at < NOW() - INTERVAL ? SECOND FROM blobs WHERE sha256 = ? FOR UPDATE", seconds, sum).Scan(&stale)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !stale) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blobs WHERE sha256 = ?", sum); err != nil {
+		return false, err
+	}
+	if err := s.backend.Delete(ctx, blobName(sum)); err != nil && !errors.Is(err, ErrNotFound) {
+		return false, fmt.Errorf("deleting blob %s: %w", sum, err)
+	}
+	return true, tx.Commit()
+}
+
+// StartGarbageCollector runs CollectGarbage every interval until ctx is done.
+func (s *ContentAddressed) StartGarbageCollector(ctx context.Context, interval, grace time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				removed, err := s.CollectGarbage(ctx, grace)
+				if err != nil {
+					fmt.Println("Error collecting unreferenced blobs:", err)
+				} else if removed > 0 {
+					fmt.Printf("Removed %d unreferenced blobs\n", removed)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// countingStorage counts the uploads that reach the backend.
+type countingStorage struct {
+	Storage
+	mu   sync.Mutex
+	puts map[string]int
+}
+
+func (s *countingStorage) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	s.mu.Lock()
+	s.puts[name]++
+	s.mu.Unlock()
+	return s.Storage.Put(ctx, name, r, size, contentType)
+}
+
+func newTestContentAddressed(t *testing.T) (*ContentAddressed, sqlmock.Sqlmock, *countingStorage) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &countingStorage{Storage: local, puts: map[string]int{}}
+	return NewContentAddressed(backend, db), mock, backend
+}
+
+func quoteSQL(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+func sumOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// expectPut expects the statements of a Put of data to name, previous is
+// the blob name pointed at before or "" when it had no reference.
+func expectPut(mock sqlmock.Sqlmock, name string, data []byte, previous string) {
+	sum := sumOf(data)
+	mock.ExpectExec(quoteSQL("INSERT INTO blobs")).WithArgs(sum, len(data), "image/png").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	rows := sqlmock.NewRows([]string{"sha256"})
+	if previous != "" {
+		rows.AddRow(previous)
+	}
+	mock.ExpectQuery(quoteSQL("SELECT sha256 FROM blob_refs WHERE name = ? FOR UPDATE")).WithArgs(name).WillReturnRows(rows)
+	switch previous {
+	case "":
+		mock.ExpectExec(quoteSQL("INSERT INTO blob_refs")).WithArgs(name, sum).WillReturnResult(sqlmock.NewResult(0, 1))
+	case sum:
+		mock.ExpectExec(quoteSQL("UPDATE blob_refs SET updated_at")).WithArgs(name).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		return
+	default:
+		mock.ExpectExec(quoteSQL("UPDATE blob_refs SET sha256 = ?")).WithArgs(sum, name).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(quoteSQL("ref_count = ref_count - 1")).WithArgs(previous).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(quoteSQL("ref_count = ref_count + 1")).WithArgs(sum).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func put(t *testing.T, s *ContentAddressed, name string, data []byte) {
+	t.Helper()
+	if err := s.Put(context.Background(), name, bytes.NewReader(data), int64(len(data)), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(t *testing.T, s Storage, name string) bool {
+	t.Helper()
+	_, err := s.Stat(context.Background(), name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestContentAddressedDedup(t *testing.T) {
+	s, mock, backend := newTestContentAddressed(t)
+	data := []byte("the same texture")
+	blob := blobName(sumOf(data))
+	// a file written before the blob store was enabled
+	if err := backend.Storage.Put(context.Background(), "a.png", bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPut(mock, "a.png", data, "")
+	put(t, s, "a.png", data)
+	expectPut(mock, "b.png", data, "")
+	put(t, s, "b.png", data)
+
+	if backend.puts[blob] != 1 {
+		t.Fatalf("the blob was uploaded %d times, want once", backend.puts[blob])
+	}
+	if exists(t, backend, "a.png") {
+		t.Fatal("the legacy copy of a.png was kept")
+	}
+}
+
+func TestContentAddressedOverwrite(t *testing.T) {
+	s, mock, backend := newTestContentAddressed(t)
+	old, data := []byte("old texture"), []byte("new texture")
+
+	// the reference moves, the old blob loses one and the new gains one
+	expectPut(mock, "a.png", data, sumOf(old))
+	put(t, s, "a.png", data)
+	// the same content again only touches the reference
+	expectPut(mock, "a.png", data, sumOf(data))
+	put(t, s, "a.png", data)
+
+	if backend.puts[blobName(sumOf(data))] != 1 {
+		t.Fatalf("the blob was uploaded %d times, want once", backend.puts[blobName(sumOf(data))])
+	}
+}
+
+func TestContentAddressedDelete(t *testing.T) {
+	s, mock, backend := newTestContentAddressed(t)
+	ctx := context.Background()
+	sum := sumOf([]byte("texture"))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(quoteSQL("SELECT sha256 FROM blob_refs WHERE name = ? FOR UPDATE")).WithArgs("a.png").
+		WillReturnRows(sqlmock.NewRows([]string{"sha256"}).AddRow(sum))
+	mock.ExpectExec(quoteSQL("DELETE FROM blob_refs WHERE name = ?")).WithArgs("a.png").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(quoteSQL("ref_count = ref_count - 1")).WithArgs(sum).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := s.Delete(ctx, "/a.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a reference the legacy file is deleted
+	if err := backend.Storage.Put(ctx, "legacy.png", bytes.NewReader([]byte("x")), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(quoteSQL("SELECT sha256 FROM blob_refs WHERE name = ? FOR UPDATE")).WithArgs("legacy.png").
+		WillReturnRows(sqlmock.NewRows([]string{"sha256"}))
+	mock.ExpectRollback()
+	if err := s.Delete(ctx, "legacy.png"); err != nil {
+		t.Fatal(err)
+	}
+	if exists(t, backend, "legacy.png") {
+		t.Fatal("legacy.png was kept")
+	}
+
+	// names are checked before they reach the database
+	if err := s.Delete(ctx, "../a.png"); err == nil {
+		t.Fatal("deleting ../a.png succeeded")
+	}
+}
+
+func TestContentAddressedCollectGarbage(t *testing.T) {
+	s, mock, backend := newTestContentAddressed(t)
+	ctx := context.Background()
+	stale, pickedUp, gone := []byte("stale"), []byte("picked up again"), []byte("gone")
+	for _, data := range [][]byte{stale, pickedUp} {
+		if err := backend.Put(ctx, blobName(sumOf(data)), bytes.NewReader(data), int64(len(data)), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mock.ExpectQuery(quoteSQL("SELECT sha256 FROM blobs WHERE ref_count <= 0 AND updated_at < NOW() - INTERVAL ? SECOND")).WithArgs(3600).
+		WillReturnRows(sqlmock.NewRows([]string{"sha256"}).AddRow(sumOf(stale)).AddRow(sumOf(pickedUp)).AddRow(sumOf(gone)))
+	lock := quoteSQL("FROM blobs WHERE sha256 = ? FOR UPDATE")
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs(3600, sumOf(stale)).WillReturnRows(sqlmock.NewRows([]string{"stale"}).AddRow(true))
+	mock.ExpectExec(quoteSQL("DELETE FROM blobs WHERE sha256 = ?")).WithArgs(sumOf(stale)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// a Put touched the row or referenced the blob since the select
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs(3600, sumOf(pickedUp)).WillReturnRows(sqlmock.NewRows([]string{"stale"}).AddRow(false))
+	mock.ExpectRollback()
+	// another collector got there first
+	mock.ExpectBegin()
+	mock.ExpectQuery(lock).WithArgs(3600, sumOf(gone)).WillReturnRows(sqlmock.NewRows([]string{"stale"}))
+	mock.ExpectRollback()
+
+	removed, err := s.CollectGarbage(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("removed %d blobs, want 1", removed)
+	}
+	if exists(t, backend, blobName(sumOf(stale))) {
+		t.Fatal("the stale blob was kept")
+	}
+	if !exists(t, backend, blobName(sumOf(pickedUp))) {
+		t.Fatal("the blob picked up again was removed")
+	}
+}
+
+// TestContentAddressedPutAfterCollection is a Put whose upsert waited on the
+// row lock of a collection of the same content, it has to upload the blob
+// again rather than reference the file the collection removed.
+func TestContentAddressedPutAfterCollection(t *testing.T) {
+	s, mock, backend := newTestContentAddressed(t)
+	ctx := context.Background()
+	data := []byte("texture")
+	blob := blobName(sumOf(data))
+	if err := backend.Put(ctx, blob, bytes.NewReader(data), int64(len(data)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(quoteSQL("SELECT sha256 FROM blobs WHERE ref_count <= 0")).WithArgs(0).
+		WillReturnRows(sqlmock.NewRows([]string{"sha256"}).AddRow(sumOf(data)))
+	mock.ExpectBegin()
+	mock.ExpectQuery(quoteSQL("FROM blobs WHERE sha256 = ? FOR UPDATE")).WithArgs(0, sumOf(data)).
+		WillReturnRows(sqlmock.NewRows([]string{"stale"}).AddRow(true))
+	mock.ExpectExec(quoteSQL("DELETE FROM blobs WHERE sha256 = ?")).WithArgs(sumOf(data)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectPut(mock, "a.png", data, "")
+
+	if _, err := s.CollectGarbage(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a.png", data)
+
+	if backend.puts[blob] != 2 {
+		t.Fatalf("the blob was uploaded %d times, want it uploaded again after the collection", backend.puts[blob])
+	}
+	if !exists(t, backend, blob) {
+		t.Fatal("a.png references a blob that is not stored")
+	}
+}
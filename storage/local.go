@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Local struct {
+	root string
+	// hashes caches the SHA-256 of files by path, see contentHash.
+	hashes sync.Map
+}
+
+type localHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+type localObject struct {
+	*os.File
+	info ObjectInfo
+}
+
+func (o *localObject) Info() ObjectInfo {
+	return o.info
+}
+
+func NewLocal(root string) (*Local, error) {
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		if err := os.Mkdir(root, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &Local{root: root}, nil
+}
+
+func (s *Local) path(name string) (string, error) {
+	cleaned, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *Local) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Local) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	return s.Open(ctx, name)
+}
+
+func (s *Local) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return ObjectInfo{}, mapError(err)
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+	info := fileInfo(name, fi)
+	info.ETag, err = s.contentHash(p, fi, func() (io.Reader, func(), error) {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, func() { f.Close() }, nil
+	})
+	if err != nil {
+		return ObjectInfo{}, mapError(err)
+	}
+	return info, nil
+}
+
+func (s *Local) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	dir, base := s.root, ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		p, err := s.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		dir, base = p, prefix[:i+1]
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []ObjectInfo{}, nil
+		}
+		return nil, err
+	}
+	response := []ObjectInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := base + entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		response = append(response, fileInfo(name, fi))
+	}
+	return response, nil
+}
+
+func (s *Local) Delete(ctx context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	s.hashes.Delete(p)
+	return mapError(os.Remove(p))
+}
+
+func (s *Local) Open(ctx context.Context, name string) (Object, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, mapError(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	info := fileInfo(name, fi)
+	info.ETag, err = s.contentHash(p, fi, func() (io.Reader, func(), error) {
+		return f, func() {}, nil
+	})
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &localObject{File: f, info: info}, nil
+}
+
+// contentHash returns the hex SHA-256 of the file at p. It is remembered
+// until the size or modification time of the file changes, so only the
+// first request after a write reads the whole file.
+func (s *Local) contentHash(p string, fi fs.FileInfo, open func() (io.Reader, func(), error)) (string, error) {
+	if cached, ok := s.hashes.Load(p); ok {
+		h := cached.(localHash)
+		if h.size == fi.Size() && h.modTime.Equal(fi.ModTime()) {
+			return h.sum, nil
+		}
+	}
+	r, done, err := open()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, r); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	s.hashes.Store(p, localHash{size: fi.Size(), modTime: fi.ModTime(), sum: sum})
+	return sum, nil
+}
+
+func fileInfo(name string, fi fs.FileInfo) ObjectInfo {
+	return ObjectInfo{
+		Name:        name,
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+	}
+}
+
+func mapError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
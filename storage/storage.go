@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"lorraxs/fivem_cdn_server/config"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+type ObjectInfo struct {
+	Name        string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+	ETag        string
+}
+
+// Object is an opened stored file. It is seekable so it can be handed to
+// http.ServeContent, which takes care of Range and conditional requests.
+type Object interface {
+	io.ReadSeekCloser
+	Info() ObjectInfo
+}
+
+// Storage is where uploaded images live. Names are slash separated and
+// relative to the storage root, e.g. "collection-0-component-11-6-1.webp".
+type Storage interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	Stat(ctx context.Context, name string) (ObjectInfo, error)
+	// List returns the objects directly under prefix, it does not descend
+	// into nested "directories".
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, name string) error
+	Open(ctx context.Context, name string) (Object, error)
+}
+
+func New(cfg *config.Config) (Storage, error) {
+	switch cfg.Storage.Driver {
+	case "", "local":
+		return NewLocal(cfg.App.UploadPath)
+	case "s3":
+		return NewS3(S3Options{
+			Endpoint:  cfg.Storage.Endpoint,
+			Region:    cfg.Storage.Region,
+			Bucket:    cfg.Storage.Bucket,
+			AccessKey: cfg.Storage.AccessKey,
+			SecretKey: cfg.Storage.SecretKey,
+			Prefix:    cfg.Storage.Prefix,
+			PathStyle: cfg.Storage.PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", cfg.Storage.Driver)
+	}
+}
+
+// Serve writes the named object to w, honouring Range and If-Modified-Since
+// the same way http.ServeFile does. The content hash of the object becomes a
+// strong ETag so If-None-Match gets a 304, and cacheControl is only sent
+// once the object is known to exist.
+func Serve(w http.ResponseWriter, r *http.Request, s Storage, name string, cacheControl string) {
+	obj, err := s.Open(r.Context(), name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	info := obj.Info()
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", `"`+strings.Trim(info.ETag, `"`)+`"`)
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	http.ServeContent(w, r, info.Name, info.ModTime, obj)
+}
+
+func cleanName(name string) (string, error) {
+	if name == "" || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("storage: invalid object name %q", name)
+	}
+	cleaned := path.Clean("/" + name)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(name, "/") {
+		return "", fmt.Errorf("storage: invalid object name %q", name)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Anchor string
+
+const (
+	AnchorCenter Anchor = "center"
+	AnchorBottom Anchor = "bottom"
+)
+
+// CanvasOptions places an image on a transparent canvas of a fixed aspect
+// ratio. Padding is a percentage of the canvas kept clear on every side.
+type CanvasOptions struct {
+	AspectWidth  int     `json:"aspectWidth"`
+	AspectHeight int     `json:"aspectHeight"`
+	Padding      float64 `json:"padding"`
+	Anchor       Anchor  `json:"anchor"`
+}
+
+// ParseAspect reads an aspect ratio written as "WxH" or "W:H".
+func ParseAspect(s string) (int, int, bool) {
+	w, h, ok := strings.Cut(s, "x")
+	if !ok {
+		w, h, ok = strings.Cut(s, ":")
+	}
+	if !ok {
+		return 0, 0, false
+	}
+	aw, err1 := strconv.Atoi(strings.TrimSpace(w))
+	ah, err2 := strconv.Atoi(strings.TrimSpace(h))
+	if err1 != nil || err2 != nil || aw <= 0 || ah <= 0 {
+		return 0, 0, false
+	}
+	return aw, ah, true
+}
+
+func ParseAnchor(s string) (Anchor, bool) {
+	switch Anchor(strings.ToLower(s)) {
+	case AnchorCenter:
+		return AnchorCenter, true
+	case AnchorBottom:
+		return AnchorBottom, true
+	}
+	return "", false
+}
+
+// Recanvas grows the canvas around img until it matches the aspect ratio
+// with the requested padding, the image itself is never scaled. Bottom
+// anchored images rest on the bottom padding instead of being centered
+// vertically.
+func Recanvas(img image.Image, opts CanvasOptions) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 || opts.AspectWidth <= 0 || opts.AspectHeight <= 0 {
+		return img
+	}
+	pad := math.Min(math.Max(opts.Padding, 0), 45) / 100
+	inner := 1 - 2*pad
+	aspect := float64(opts.AspectWidth) / float64(opts.AspectHeight)
+
+	cw := math.Max(float64(w)/inner, float64(h)*aspect/inner)
+	ch := cw / aspect
+	canvasW, canvasH := int(math.Ceil(cw)), int(math.Ceil(ch))
+
+	x := (canvasW - w) / 2
+	y := (canvasH - h) / 2
+	if opts.Anchor == AnchorBottom {
+		y = canvasH - h - int(math.Round(ch*pad))
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasW, canvasH))
+	draw.Draw(canvas, image.Rect(x, y, x+w, y+h), img, bounds.Min, draw.Src)
+	return canvas
+}
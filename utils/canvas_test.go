@@ -0,0 +1,17 @@
+package utils
+
+import "testing"
+
+func TestRecanvasGolden(t *testing.T) {
+	input := greenScreenFixture(t)
+	for _, tc := range []struct {
+		name string
+		opts CanvasOptions
+	}{
+		{"canvas_square_center.png", CanvasOptions{AspectWidth: 1, AspectHeight: 1, Padding: 10, Anchor: AnchorCenter}},
+		{"canvas_portrait_bottom.png", CanvasOptions{AspectWidth: 3, AspectHeight: 4, Padding: 5, Anchor: AnchorBottom}},
+	} {
+		// placing pixels is exact, nothing is resampled
+		compareGolden(t, tc.name, Recanvas(input, tc.opts), 0)
+	}
+}
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ChromaKeyOptions drives the background removal. Hue values are degrees,
+// saturation and value thresholds are 0-1.
+type ChromaKeyOptions struct {
+	Key           string  `json:"key"`
+	Hue           float64 `json:"hue"`
+	Tolerance     float64 `json:"tolerance"`
+	Softness      float64 `json:"softness"`
+	MinSaturation float64 `json:"minSaturation"`
+	MinValue      float64 `json:"minValue"`
+	Spill         bool    `json:"spill"`
+	SpillRange    float64 `json:"spillRange"`
+	// MinArea drops opaque islands smaller than this many pixels after keying.
+	MinArea int `json:"minArea"`
+}
+
+var chromaKeyHues = map[string]float64{
+	"green":   120,
+	"blue":    240,
+	"magenta": 300,
+}
+
+func DefaultChromaKeyOptions() ChromaKeyOptions {
+	return ChromaKeyOptions{
+		Key:           "green",
+		Hue:           chromaKeyHues["green"],
+		Tolerance:     30,
+		Softness:      20,
+		MinSaturation: 0.3,
+		MinValue:      0.45,
+		Spill:         true,
+		SpillRange:    30,
+		MinArea:       64,
+	}
+}
+
+// ParseChromaKeyOptions reads key, tolerance, softness, minSat, minVal,
+// spill, spillRange and minArea from the query on top of opts. key is green,
+// blue, magenta or a #rrggbb colour.
+func ParseChromaKeyOptions(query url.Values, opts ChromaKeyOptions) (ChromaKeyOptions, error) {
+	if key := strings.ToLower(query.Get("key")); key != "" {
+		if hue, ok := chromaKeyHues[key]; ok {
+			opts.Key, opts.Hue = key, hue
+		} else if hue, ok := hexHue(key); ok {
+			opts.Key, opts.Hue = key, hue
+		} else {
+			return opts, fmt.Errorf("invalid key parameter %q", key)
+		}
+	}
+	floats := []struct {
+		name     string
+		dst      *float64
+		min, max float64
+	}{
+		{"tolerance", &opts.Tolerance, 0, 180},
+		{"softness", &opts.Softness, 0, 180},
+		{"minSat", &opts.MinSaturation, 0, 1},
+		{"minVal", &opts.MinValue, 0, 1},
+		{"spillRange", &opts.SpillRange, 0, 180},
+	}
+	for _, f := range floats {
+		v := query.Get(f.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < f.min || n > f.max {
+			return opts, fmt.Errorf("invalid %s parameter", f.name)
+		}
+		*f.dst = n
+	}
+	if v := query.Get("minArea"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, errors.New("invalid minArea parameter")
+		}
+		opts.MinArea = n
+	}
+	if v := query.Get("spill"); v != "" {
+		opts.Spill = v == "true"
+	}
+	return opts, nil
+}
+
+func hexHue(s string) (float64, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	h, sat, _ := rgbToHsv(uint8(n>>16), uint8(n>>8), uint8(n))
+	return h, sat > 0
+}
+
+// rgbToHsv returns hue in degrees, saturation and value in 0-1.
+func rgbToHsv(r, g, b uint8) (h, s, v float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	v = max
+	d := max - min
+	if max > 0 {
+		s = d / max
+	}
+	if d == 0 {
+		return 0, s, v
+	}
+	switch max {
+	case rf:
+		h = math.Mod((gf-bf)/d, 6)
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, v
+}
+
+func hueDistance(a, b float64) float64 {
+	d := math.Abs(a - b)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+// ChromaKey makes pixels close to the key hue transparent. Pixels within
+// tolerance are removed, the next softness degrees fade out for soft edges,
+// and low saturation or dark pixels are never keyed so greys, blacks and
+// dark green fabric survive. With spill enabled the key colour cast is
+// removed from the remaining pixels near the key hue.
+func ChromaKey(img image.Image, opts ChromaKeyOptions) *image.NRGBA {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	const edge = 0.1
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		r, g, b, a := pix[i], pix[i+1], pix[i+2], pix[i+3]
+		if a == 0 {
+			continue
+		}
+		h, s, v := rgbToHsv(r, g, b)
+		if s < opts.MinSaturation || v < opts.MinValue {
+			continue
+		}
+		d := hueDistance(h, opts.Hue)
+
+		// 0 = keep, 1 = fully keyed
+		strength := 0.0
+		switch {
+		case d <= opts.Tolerance:
+			strength = 1
+		case opts.Softness > 0 && d < opts.Tolerance+opts.Softness:
+			strength = 1 - (d-opts.Tolerance)/opts.Softness
+		}
+		// fade in from the saturation and value thresholds instead of a hard cut
+		strength *= math.Min(1, (s-opts.MinSaturation)/edge) * math.Min(1, (v-opts.MinValue)/edge)
+		if strength > 0 {
+			pix[i+3] = uint8(math.Round(float64(a) * (1 - strength)))
+		}
+
+		if opts.Spill && pix[i+3] > 0 && d < opts.Tolerance+opts.Softness+opts.SpillRange {
+			pix[i], pix[i+1], pix[i+2] = suppressSpill(opts.Hue, r, g, b)
+		}
+	}
+	return dst
+}
+
+// suppressSpill clamps the key channels to the strongest other channel,
+// which removes the colour cast the screen reflects onto the subject.
+func suppressSpill(keyHue float64, r, g, b uint8) (uint8, uint8, uint8) {
+	switch {
+	case hueDistance(keyHue, 120) <= 60:
+		g = min(g, max(r, b))
+	case hueDistance(keyHue, 240) <= 60:
+		b = min(b, max(r, g))
+	default:
+		// magenta: red and blue together
+		if m := min(r, b); m > g {
+			r, b = r-(m-g), b-(m-g)
+		}
+	}
+	return r, g, b
+}
+
+// RemoveBackground chroma keys img and trims the transparent border.
+func RemoveBackground(img image.Image, opts ChromaKeyOptions) (image.Image, error) {
+	return TrimImage(ChromaKey(img, opts), opts.MinArea)
+}
@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+// Keying is float math, a channel may round one step differently across
+// platforms.
+const chromaTolerance = 1
+
+func TestChromaKeyGolden(t *testing.T) {
+	input := greenScreenFixture(t)
+	opts := DefaultChromaKeyOptions()
+	compareGolden(t, "chroma_green.png", ChromaKey(input, opts), chromaTolerance)
+
+	opts.Spill = false
+	compareGolden(t, "chroma_green_nospill.png", ChromaKey(input, opts), chromaTolerance)
+}
+
+func TestRemoveBackgroundGolden(t *testing.T) {
+	input := greenScreenFixture(t)
+	img, err := RemoveBackground(input, DefaultChromaKeyOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareGolden(t, "remove_background.png", img, chromaTolerance)
+}
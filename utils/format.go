@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chai2010/webp"
+)
+
+type Format string
+
+const (
+	FormatWebp Format = "webp"
+	FormatPng  Format = "png"
+	FormatJpeg Format = "jpeg"
+)
+
+// Encoder writes img in a given format, quality is 1-100.
+type Encoder func(w io.Writer, img image.Image, quality int) error
+
+var encoders = map[Format]Encoder{
+	FormatWebp: func(w io.Writer, img image.Image, quality int) error {
+		return webp.Encode(w, img, &webp.Options{Quality: float32(quality)})
+	},
+	FormatPng: func(w io.Writer, img image.Image, quality int) error {
+		return png.Encode(w, img)
+	},
+	FormatJpeg: func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	},
+}
+
+// negotiationOrder is the preference order when the client accepts several
+// formats equally. AVIF is not offered, the server links no AVIF encoder.
+var negotiationOrder = []Format{FormatWebp, FormatPng, FormatJpeg}
+
+func HasEncoder(format Format) bool {
+	_, ok := encoders[format]
+	return ok
+}
+
+func ParseFormat(s string) (Format, bool) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "webp":
+		return FormatWebp, true
+	case "png":
+		return FormatPng, true
+	case "jpg", "jpeg":
+		return FormatJpeg, true
+	}
+	return "", false
+}
+
+func (f Format) ContentType() string {
+	return "image/" + string(f)
+}
+
+func (f Format) Ext() string {
+	if f == FormatJpeg {
+		return ".jpg"
+	}
+	return "." + string(f)
+}
+
+func EncodeImage(w io.Writer, img image.Image, format Format, quality int) error {
+	encoder, ok := encoders[format]
+	if !ok {
+		return fmt.Errorf("no encoder for %s", format)
+	}
+	return encoder(w, img, quality)
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func parseAccept(accept string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	best, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case r.mediaType == "image/*":
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			best, specificity = r.q, s
+		}
+	}
+	return best
+}
+
+// NegotiateFormat picks the output format for an Accept header. Explicitly
+// listed formats win over wildcards, and the source format is kept when the
+// client only sends wildcards. ok is false when nothing encodable is
+// acceptable.
+func NegotiateFormat(accept string, source Format) (format Format, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return source, HasEncoder(source)
+	}
+	ranges := parseAccept(accept)
+	type candidate struct {
+		format   Format
+		q        float64
+		explicit bool
+		order    int
+	}
+	candidates := []candidate{}
+	for i, f := range negotiationOrder {
+		if !HasEncoder(f) {
+			continue
+		}
+		q := acceptQuality(ranges, f.ContentType())
+		if q <= 0 {
+			continue
+		}
+		explicit := false
+		for _, r := range ranges {
+			if r.mediaType == f.ContentType() {
+				explicit = true
+			}
+		}
+		if !explicit && f != source {
+			continue
+		}
+		candidates = append(candidates, candidate{format: f, q: q, explicit: explicit, order: i})
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		if candidates[i].explicit != candidates[j].explicit {
+			return candidates[i].explicit
+		}
+		return candidates[i].order < candidates[j].order
+	})
+	return candidates[0].format, true
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+func readPNG(t *testing.T, name string) image.Image {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func writePNG(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// compareGolden checks got against testdata/name, allowing every channel of
+// every pixel to be off by tolerance. Run go test -update to rewrite the
+// golden image after an intended change.
+func compareGolden(t *testing.T, name string, got image.Image, tolerance uint8) {
+	t.Helper()
+	if *update {
+		writePNG(t, name, got)
+		return
+	}
+	want := toNRGBA(readPNG(t, name))
+	have := toNRGBA(got)
+	if have.Rect.Size() != want.Rect.Size() {
+		t.Fatalf("%s: got a %v image, want %v", name, have.Rect.Size(), want.Rect.Size())
+	}
+	mismatches := 0
+	for i := range want.Pix {
+		d := int(have.Pix[i]) - int(want.Pix[i])
+		if d < -int(tolerance) || d > int(tolerance) {
+			if mismatches == 0 {
+				p := i / 4
+				t.Errorf("%s: first mismatch at (%d, %d) channel %d: got %d, want %d",
+					name, p%want.Rect.Dx(), p/want.Rect.Dx(), i%4, have.Pix[i], want.Pix[i])
+			}
+			mismatches++
+		}
+	}
+	if mismatches > 0 {
+		t.Errorf("%s: %d channel values differ by more than %d", name, mismatches, tolerance)
+	}
+}
+
+func toNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := img.Bounds()
+	n := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(n, n.Rect, img, b.Min, draw.Src)
+	return n
+}
+
+// greenScreenFixture reads testdata/greenscreen.png, which -update redraws
+// from greenScreen.
+func greenScreenFixture(t *testing.T) image.Image {
+	t.Helper()
+	if *update {
+		writePNG(t, "greenscreen.png", greenScreen())
+	}
+	return readPNG(t, "greenscreen.png")
+}
+
+// greenScreen draws the input fixture: a green screen with a red garment, a
+// grey stripe, a dark green patch that must survive keying, a soft edge
+// with green spill and a speck of noise small enough to be dropped.
+func greenScreen() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 96, 64))
+	fill := func(r image.Rectangle, c color.NRGBA) {
+		draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	fill(img.Rect, color.NRGBA{40, 200, 60, 255})
+	fill(image.Rect(24, 12, 72, 56), color.NRGBA{200, 40, 50, 255})
+	fill(image.Rect(24, 30, 72, 34), color.NRGBA{128, 128, 128, 255})
+	fill(image.Rect(40, 40, 56, 50), color.NRGBA{20, 70, 30, 255})
+	for y := 12; y < 56; y++ {
+		// blend of the garment and the screen along the left edge
+		fill(image.Rect(22, y, 24, y+1), color.NRGBA{120, 150, 55, 255})
+	}
+	fill(image.Rect(4, 4, 6, 6), color.NRGBA{220, 220, 220, 255})
+	return img
+}
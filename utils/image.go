@@ -3,140 +3,140 @@ package utils
 import (
 	"fmt"
 	"image"
-	"image/color"
+	"image/draw"
 )
 
+// RemoveGreenBackground removes a green screen with the default chroma key
+// options.
 func RemoveGreenBackground(img image.Image) (image.Image, error) {
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	newImage := image.NewRGBA(bounds)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			r, g, b, a := img.At(x, y).RGBA()
-			pixel := RgbaToPixel(r, g, b, a)
-			if pixel.R+pixel.B < pixel.G {
-				pixel.A = 0
-			}
-			newImage.Set(x, y, pixel.ToRgba())
-		}
-	}
-
-	trimmedImage, err := TrimImage(newImage, 20)
-
-	return trimmedImage, err
+	return RemoveBackground(img, DefaultChromaKeyOptions())
 }
 
-func TrimImage(img image.Image, minSize int) (image.Image, error) {
+// TrimImage drops opaque islands smaller than minArea pixels and crops the
+// image to the bounding box of what is left.
+func TrimImage(img image.Image, minArea int) (image.Image, error) {
 	fmt.Println("Trimming image...")
 	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
+	newImage := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(newImage, newImage.Bounds(), img, bounds.Min, draw.Src)
 
-	newImage := image.NewRGBA(bounds)
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			newImage.Set(x, y, img.At(x, y))
-		}
-	}
-
-	removeSmallParticles(newImage, minSize)
+	removeSmallParticles(newImage, minArea)
 
 	minX, minY, maxX, maxY := findBoundingBox(newImage)
 	fmt.Printf("minX: %d, minY: %d, maxX: %d, maxY: %d\n", minX, minY, maxX, maxY)
-
-	trimmedImage := image.NewRGBA(image.Rect(0, 0, maxX-minX, maxY-minY))
-	for y := minY; y < maxY; y++ {
-		for x := minX; x < maxX; x++ {
-			trimmedImage.Set(x-minX, y-minY, newImage.At(x, y))
-		}
+	if maxX < minX || maxY < minY {
+		return image.NewRGBA(image.Rect(0, 0, 0, 0)), nil
 	}
 
+	trimmedImage := image.NewRGBA(image.Rect(0, 0, maxX-minX+1, maxY-minY+1))
+	draw.Draw(trimmedImage, trimmedImage.Bounds(), newImage, image.Pt(minX, minY), draw.Src)
+
 	return trimmedImage, nil
 }
 
-func removeSmallParticles(img *image.RGBA, minSize int) {
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
-				if isSmallParticle(img, x, y, minSize, 0, 1) {
-					removeParticle(img, x, y, minSize)
-				}
-			}
-		}
+// removeSmallParticles labels the 8-connected components of non transparent
+// pixels with a two pass union-find over the Pix buffer and clears every
+// component covering fewer than minArea pixels.
+func removeSmallParticles(img *image.RGBA, minArea int) {
+	if minArea <= 1 {
+		return
 	}
-
-	for y := 0; y < height; y++ {
-		for x := width - 1; x >= 0; x-- {
-			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
-				if isSmallParticle(img, x, y, minSize, -1, 0) {
-					removeParticle(img, x, y, minSize)
-				}
-			}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	pix, stride := img.Pix, img.Stride
+
+	// labels[i] is 0 for transparent pixels, otherwise a 1-based index into parent
+	labels := make([]int32, width*height)
+	parent := []int32{0}
+	find := func(l int32) int32 {
+		for parent[l] != l {
+			parent[l] = parent[parent[l]]
+			l = parent[l]
 		}
+		return l
 	}
-
-	for x := 0; x < width; x++ {
-		for y := height - 1; y >= 0; y-- {
-			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
-				if isSmallParticle(img, x, y, minSize, 0, -1) {
-					removeParticle(img, x, y, minSize)
-				}
-			}
+	union := func(a, b int32) int32 {
+		a, b = find(a), find(b)
+		if a == b {
+			return a
 		}
+		if a < b {
+			parent[b] = a
+			return a
+		}
+		parent[a] = b
+		return b
 	}
 
 	for y := 0; y < height; y++ {
+		row := y * width
 		for x := 0; x < width; x++ {
-			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
-				if isSmallParticle(img, x, y, minSize, 1, 0) {
-					removeParticle(img, x, y, minSize)
+			if pix[y*stride+x*4+3] == 0 {
+				continue
+			}
+			var label int32
+			link := func(n int32) {
+				if n == 0 {
+					return
+				}
+				if label == 0 {
+					label = n
+				} else {
+					label = union(label, n)
+				}
+			}
+			if x > 0 {
+				link(labels[row+x-1])
+			}
+			if y > 0 {
+				prev := row - width
+				if x > 0 {
+					link(labels[prev+x-1])
 				}
+				link(labels[prev+x])
+				if x < width-1 {
+					link(labels[prev+x+1])
+				}
+			}
+			if label == 0 {
+				label = int32(len(parent))
+				parent = append(parent, label)
 			}
+			labels[row+x] = label
 		}
 	}
-}
 
-func isSmallParticle(img *image.RGBA, x, y, minSize, dx, dy int) bool {
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-
-	for i := 0; i < minSize; i++ {
-		nx, ny := x+i*dx, y+i*dy
-		if nx < 0 || nx >= width || ny < 0 || ny >= height {
-			return false
-		}
-		if _, _, _, a := img.At(nx, ny).RGBA(); a != 0 {
-			return false
+	area := make([]int, len(parent))
+	for i, l := range labels {
+		if l != 0 {
+			l = find(l)
+			labels[i] = l
+			area[l]++
 		}
 	}
 
-	return true
-}
-
-func removeParticle(img *image.RGBA, x, y, minSize int) {
-	for dy := 0; dy < minSize; dy++ {
-		for dx := 0; dx < minSize; dx++ {
-			img.Set(x+dx, y+dy, color.RGBA{0, 0, 0, 0})
+	for y := 0; y < height; y++ {
+		row := y * width
+		for x := 0; x < width; x++ {
+			if l := labels[row+x]; l != 0 && area[l] < minArea {
+				i := y*stride + x*4
+				pix[i], pix[i+1], pix[i+2], pix[i+3] = 0, 0, 0, 0
+			}
 		}
 	}
 }
 
+// findBoundingBox returns the inclusive bounds of the non transparent
+// pixels, maxX < minX when the image is empty.
 func findBoundingBox(img *image.RGBA) (minX, minY, maxX, maxY int) {
-	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
+	width, height := img.Rect.Dx(), img.Rect.Dy()
 
 	minX, minY = width, height
-	maxX, maxY = 0, 0
+	maxX, maxY = -1, -1
 
 	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
 		for x := 0; x < width; x++ {
-			_, _, _, a := img.At(x, y).RGBA()
-			if a != 0 {
+			if row[x*4+3] != 0 {
 				if x < minX {
 					minX = x
 				}
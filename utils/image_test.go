@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// trimFixture is a width x height transparent image with an opaque ellipse
+// filling the middle half and specks of 1 to 3 pixels scattered around it.
+func trimFixture(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	cx, cy := float64(width)/2, float64(height)/2
+	rx, ry := float64(width)/4, float64(height)/4
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := (float64(x)-cx)/rx, (float64(y)-cy)/ry
+			if dx*dx+dy*dy <= 1 {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+			}
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < width*height/2000; i++ {
+		x, y := rng.Intn(width/8), rng.Intn(height)
+		if rng.Intn(2) == 0 {
+			x = width - 1 - x
+		}
+		size := 1 + rng.Intn(3)
+		for dy := 0; dy < size && y+dy < height; dy++ {
+			for dx := 0; dx < size && x+dx < width; dx++ {
+				img.SetNRGBA(x+dx, y+dy, color.NRGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestTrimImage(t *testing.T) {
+	img := trimFixture(320, 180)
+	trimmed, err := TrimImage(img, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the ellipse is left, from x 80 to 240 and y 45 to 135
+	if got, want := trimmed.Bounds().Size(), image.Pt(161, 91); got != want {
+		t.Fatalf("got a %v image, want %v", got, want)
+	}
+	if _, _, _, a := trimmed.At(80, 45).RGBA(); a == 0 {
+		t.Fatal("the middle of the ellipse is transparent")
+	}
+
+	trimmed, err = TrimImage(image.NewNRGBA(image.Rect(0, 0, 16, 16)), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !trimmed.Bounds().Empty() {
+		t.Fatalf("got a %v image from a transparent one, want an empty one", trimmed.Bounds().Size())
+	}
+}
+
+func BenchmarkTrimImage(b *testing.B) {
+	img := trimFixture(1920, 1080)
+	for _, bench := range []struct {
+		name string
+		trim func(image.Image, int) (image.Image, error)
+	}{
+		{"labelling", TrimImage},
+		{"sweeps", trimImageSweeps},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.trim(img, 64); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// trimImageSweeps is TrimImage as it was before the connected-component
+// labelling, kept to compare the two.
+func trimImageSweeps(img image.Image, minSize int) (image.Image, error) {
+	fmt.Println("Trimming image...")
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	newImage := image.NewRGBA(bounds)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			newImage.Set(x, y, img.At(x, y))
+		}
+	}
+
+	removeSmallParticlesSweeps(newImage, minSize)
+
+	minX, minY, maxX, maxY := findBoundingBoxSweeps(newImage)
+	fmt.Printf("minX: %d, minY: %d, maxX: %d, maxY: %d\n", minX, minY, maxX, maxY)
+
+	trimmedImage := image.NewRGBA(image.Rect(0, 0, maxX-minX, maxY-minY))
+	for y := minY; y < maxY; y++ {
+		for x := minX; x < maxX; x++ {
+			trimmedImage.Set(x-minX, y-minY, newImage.At(x, y))
+		}
+	}
+
+	return trimmedImage, nil
+}
+
+func removeSmallParticlesSweeps(img *image.RGBA, minSize int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				if isSmallParticleSweeps(img, x, y, minSize, 0, 1) {
+					removeParticleSweeps(img, x, y, minSize)
+				}
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := width - 1; x >= 0; x-- {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				if isSmallParticleSweeps(img, x, y, minSize, -1, 0) {
+					removeParticleSweeps(img, x, y, minSize)
+				}
+			}
+		}
+	}
+
+	for x := 0; x < width; x++ {
+		for y := height - 1; y >= 0; y-- {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				if isSmallParticleSweeps(img, x, y, minSize, 0, -1) {
+					removeParticleSweeps(img, x, y, minSize)
+				}
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				if isSmallParticleSweeps(img, x, y, minSize, 1, 0) {
+					removeParticleSweeps(img, x, y, minSize)
+				}
+			}
+		}
+	}
+}
+
+func isSmallParticleSweeps(img *image.RGBA, x, y, minSize, dx, dy int) bool {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	for i := 0; i < minSize; i++ {
+		nx, ny := x+i*dx, y+i*dy
+		if nx < 0 || nx >= width || ny < 0 || ny >= height {
+			return false
+		}
+		if _, _, _, a := img.At(nx, ny).RGBA(); a != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func removeParticleSweeps(img *image.RGBA, x, y, minSize int) {
+	for dy := 0; dy < minSize; dy++ {
+		for dx := 0; dx < minSize; dx++ {
+			img.Set(x+dx, y+dy, color.RGBA{0, 0, 0, 0})
+		}
+	}
+}
+
+func findBoundingBoxSweeps(img *image.RGBA) (minX, minY, maxX, maxY int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	minX, minY = width, height
+	maxX, maxY = 0, 0
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			_, _, _, a := img.At(x, y).RGBA()
+			if a != 0 {
+				if x < minX {
+					minX = x
+				}
+				if y < minY {
+					minY = y
+				}
+				if x > maxX {
+					maxX = x
+				}
+				if y > maxY {
+					maxY = y
+				}
+			}
+		}
+	}
+
+	return
+}
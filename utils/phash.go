@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// PerceptualHash holds 64 bit fingerprints that stay close for visually
+// similar images, compare them with HammingDistance.
+type PerceptualHash struct {
+	PHash uint64 `json:"phash"`
+	DHash uint64 `json:"dhash"`
+}
+
+func ComputePerceptualHash(img image.Image) PerceptualHash {
+	return PerceptualHash{PHash: PHash(img), DHash: DHash(img)}
+}
+
+// grayscale scales img to w x h luminance values, transparent pixels count
+// as black so the background does not matter.
+func grayscale(img image.Image, w, h int) []float64 {
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+	values := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			values[y*w+x] = float64(color.GrayModel.Convert(small.RGBAAt(x, y)).(color.Gray).Y)
+		}
+	}
+	return values
+}
+
+// DHash compares horizontally adjacent pixels of a 9x8 thumbnail.
+func DHash(img image.Image) uint64 {
+	values := grayscale(img, 9, 8)
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if values[y*9+x] < values[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash keeps the low frequencies of a 32x32 DCT and sets a bit for every
+// coefficient above their median.
+func PHash(img image.Image) uint64 {
+	const size, keep = 32, 8
+	values := grayscale(img, size, size)
+
+	var cos [size][size]float64
+	for u := 0; u < size; u++ {
+		for x := 0; x < size; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	// rows first, then columns, only for the coefficients we keep
+	var rows [size][keep]float64
+	for y := 0; y < size; y++ {
+		for u := 0; u < keep; u++ {
+			sum := 0.0
+			for x := 0; x < size; x++ {
+				sum += values[y*size+x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coefficients := make([]float64, 0, keep*keep)
+	for v := 0; v < keep; v++ {
+		for u := 0; u < keep; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+
+	// the DC term only carries the average brightness
+	sorted := append([]float64{}, coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitFill    = "fill"
+)
+
+type ResizeOptions struct {
+	Width  int
+	Height int
+	Fit    string
+}
+
+// Resize scales img to the requested box. With only one dimension set the
+// other one follows the aspect ratio. Images are never upscaled.
+//   - contain: fit inside the box, keeping the aspect ratio
+//   - cover: fill the box, cropping the overflow around the center
+//   - fill: stretch to exactly the box
+func Resize(img image.Image, opts ResizeOptions) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	if srcW == 0 || srcH == 0 || (opts.Width <= 0 && opts.Height <= 0) {
+		return img
+	}
+	boxW, boxH := opts.Width, opts.Height
+	if boxW <= 0 {
+		boxW = srcW * boxH / srcH
+	}
+	if boxH <= 0 {
+		boxH = srcH * boxW / srcW
+	}
+	if boxW > srcW && boxH > srcH {
+		boxW, boxH = srcW, srcH
+	}
+
+	src := bounds
+	dstW, dstH := boxW, boxH
+	switch opts.Fit {
+	case FitFill:
+	case FitCover:
+		// crop the source to the box aspect ratio
+		if srcW*boxH > srcH*boxW {
+			cropW := srcH * boxW / boxH
+			x := bounds.Min.X + (srcW-cropW)/2
+			src = image.Rect(x, bounds.Min.Y, x+cropW, bounds.Max.Y)
+		} else {
+			cropH := srcW * boxH / boxW
+			y := bounds.Min.Y + (srcH-cropH)/2
+			src = image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropH)
+		}
+	default:
+		if srcW*boxH > srcH*boxW {
+			dstH = srcH * boxW / srcW
+		} else {
+			dstW = srcW * boxH / srcH
+		}
+	}
+	dstW, dstH = max(dstW, 1), max(dstH, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lorraxs/fivem_cdn_server/config"
+	"lorraxs/fivem_cdn_server/jobs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	EventTextureUploaded   = "texture.uploaded"
+	EventTextureDeleted    = "texture.deleted"
+	EventCollectionDeleted = "collection.deleted"
+	EventPriceUpdated      = "price.updated"
+	EventCacheFlushed      = "cache.flushed"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRetrying  = "retrying"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+const deliveryJobType = "webhook"
+
+// Event is the JSON body posted to every endpoint.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"createdAt"`
+	Data      any       `json:"data"`
+}
+
+type Delivery struct {
+	ID             int64      `json:"id"`
+	EventID        string     `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Url            string     `json:"url"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus *int       `json:"responseStatus,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+}
+
+type deliveryJob struct {
+	DeliveryID int64 `json:"deliveryId"`
+}
+
+// Dispatcher records one delivery per endpoint for every emitted event and
+// posts them from the job queue, which retries failures with backoff. Each
+// request is signed in X-Webhook-Signature as t=<unix>,v1=<hex hmac> over
+// "<unix>.<body>" with the shared secret.
+type Dispatcher struct {
+	db          *sql.DB
+	queue       *jobs.Queue
+	client      *http.Client
+	endpoints   []string
+	events      map[string]bool
+	secret      string
+	maxAttempts int
+}
+
+func NewDispatcher(db *sql.DB, queue *jobs.Queue, cfg config.WebhooksSection) *Dispatcher {
+	events := map[string]bool{}
+	for _, event := range cfg.Events {
+		events[event] = true
+	}
+	endpoints := []string{}
+	for _, endpoint := range cfg.Endpoints {
+		if endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	d := &Dispatcher{
+		db:          db,
+		queue:       queue,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		endpoints:   endpoints,
+		events:      events,
+		secret:      cfg.Secret,
+		maxAttempts: cfg.MaxAttempts,
+	}
+	queue.Register(deliveryJobType, d.deliver)
+	return d
+}
+
+func (d *Dispatcher) subscribed(eventType string) bool {
+	return d.events["*"] || d.events[eventType]
+}
+
+// Emit queues eventType for every subscribed endpoint.
+func (d *Dispatcher) Emit(ctx context.Context, eventType string, data any) error {
+	if len(d.endpoints) == 0 || !d.subscribed(eventType) {
+		return nil
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	event := Event{ID: hex.EncodeToString(id), Type: eventType, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range d.endpoints {
+		res, err := d.db.ExecContext(ctx, "INSERT INTO webhook_deliveries (event_id, event_type, url, payload, status) VALUES (?, ?, ?, ?, ?)",
+			event.ID, event.Type, endpoint, string(payload), StatusPending)
+		if err != nil {
+			return err
+		}
+		deliveryID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if _, err := d.queue.EnqueueWithAttempts(ctx, deliveryJobType, deliveryJob{DeliveryID: deliveryID}, d.maxAttempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sign returns the X-Webhook-Signature value of body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, job *jobs.Job) (any, error) {
+	var payload deliveryJob
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, err
+	}
+	var eventID, eventType, url, body string
+	err := d.db.QueryRowContext(ctx, "SELECT event_id, event_type, url, payload FROM webhook_deliveries WHERE id = ?", payload.DeliveryID).
+		Scan(&eventID, &eventType, &url, &body)
+	if err != nil {
+		return nil, fmt.Errorf("loading delivery %d: %w", payload.DeliveryID, err)
+	}
+
+	statusCode, err := d.post(ctx, url, eventID, eventType, []byte(body))
+	var responseStatus any
+	if statusCode != 0 {
+		responseStatus = statusCode
+	}
+	if err == nil {
+		_, dbErr := d.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = NULL,
+			delivered_at = CURRENT_TIMESTAMP WHERE id = ?`, StatusDelivered, job.Attempts, responseStatus, payload.DeliveryID)
+		if dbErr != nil {
+			fmt.Println("Error updating webhook delivery:", dbErr)
+		}
+		return map[string]int{"status": statusCode}, nil
+	}
+
+	status := StatusRetrying
+	if job.Attempts >= job.MaxAttempts {
+		status = StatusFailed
+	}
+	_, dbErr := d.db.ExecContext(ctx, "UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ? WHERE id = ?",
+		status, job.Attempts, responseStatus, err.Error(), payload.DeliveryID)
+	if dbErr != nil {
+		fmt.Println("Error updating webhook delivery:", dbErr)
+	}
+	return nil, err
+}
+
+func (d *Dispatcher) post(ctx context.Context, url, eventID, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", eventID)
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Signature", Sign(d.secret, time.Now().Unix(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// DeliveryFilter narrows Deliveries, empty fields match everything.
+type DeliveryFilter struct {
+	EventType string
+	Status    string
+	Limit     int
+	BeforeID  int64
+}
+
+// Deliveries returns the delivery log, newest first.
+func (d *Dispatcher) Deliveries(ctx context.Context, filter DeliveryFilter) ([]Delivery, error) {
+	query := `SELECT id, event_id, event_type, url, status, attempts, response_status, error, created_at, updated_at, delivered_at
+		FROM webhook_deliveries WHERE 1 = 1`
+	args := []any{}
+	if filter.EventType != "" {
+		query += " AND event_type = ?"
+		args = append(args, filter.EventType)
+	}
+	if filter.Status != "" {
+		query += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	if filter.BeforeID > 0 {
+		query += " AND id < ?"
+		args = append(args, filter.BeforeID)
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 100
+	}
+	query += " ORDER BY id DESC LIMIT " + strconv.Itoa(filter.Limit)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []Delivery{}
+	for rows.Next() {
+		var delivery Delivery
+		var responseStatus sql.NullInt64
+		var deliveryError sql.NullString
+		var deliveredAt sql.NullTime
+		err := rows.Scan(&delivery.ID, &delivery.EventID, &delivery.EventType, &delivery.Url, &delivery.Status, &delivery.Attempts,
+			&responseStatus, &deliveryError, &delivery.CreatedAt, &delivery.UpdatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		if responseStatus.Valid {
+			status := int(responseStatus.Int64)
+			delivery.ResponseStatus = &status
+		}
+		delivery.Error = deliveryError.String
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
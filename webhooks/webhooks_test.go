@@ -0,0 +1,240 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"lorraxs/fivem_cdn_server/config"
+	"lorraxs/fivem_cdn_server/jobs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func quoteSQL(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+func newTestDispatcher(t *testing.T, cfg config.WebhooksSection) (*Dispatcher, *jobs.Queue, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// polls and heartbeats never come around during a test
+	queue := jobs.NewQueue(db, 1, 3, time.Hour, time.Hour)
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return NewDispatcher(db, queue, cfg), queue, mock
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.<body>' | openssl dgst -sha256 -hmac whsec_test
+	body := []byte(`{"id":"abc","type":"texture.uploaded"}`)
+	want := "t=1700000000,v1=c0b04677e97b6eed1a5b2a8afd4ddb4882fe55ce8f5702e7f4f3e42a079abe7c"
+	if got := Sign("whsec_test", 1700000000, body); got != want {
+		t.Fatalf("Sign returned %s, want %s", got, want)
+	}
+}
+
+// capture is a sqlmock argument that accepts anything and keeps it.
+type capture struct {
+	values *[]string
+}
+
+func (c capture) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*c.values = append(*c.values, s)
+	return ok
+}
+
+// expectEnqueue expects the queue to store a delivery job for deliveryID.
+func expectEnqueue(mock sqlmock.Sqlmock, jobID, deliveryID int64, maxAttempts int) {
+	payload := `{"deliveryId":` + strconv.FormatInt(deliveryID, 10) + `}`
+	mock.ExpectExec(quoteSQL("INSERT INTO jobs")).WithArgs(deliveryJobType, jobs.StatusQueued, payload, maxAttempts).
+		WillReturnResult(sqlmock.NewResult(jobID, 1))
+	mock.ExpectQuery(quoteSQL("FROM jobs WHERE id = ?")).WithArgs(jobID).WillReturnRows(jobRows(jobID, deliveryID, jobs.StatusQueued, 0, maxAttempts))
+}
+
+func jobRows(jobID, deliveryID int64, status string, attempts, maxAttempts int) *sqlmock.Rows {
+	payload := `{"deliveryId":` + strconv.FormatInt(deliveryID, 10) + `}`
+	return sqlmock.NewRows([]string{"id", "type", "status", "payload", "result", "error", "attempts", "max_attempts", "created_at", "started_at", "finished_at"}).
+		AddRow(jobID, deliveryJobType, status, payload, nil, nil, attempts, maxAttempts, time.Now(), nil, nil)
+}
+
+func TestEmitRecordsOneDeliveryPerEndpoint(t *testing.T) {
+	d, _, mock := newTestDispatcher(t, config.WebhooksSection{
+		Endpoints:   []string{"https://a.example.com/hook", "", "https://b.example.com/hook"},
+		Events:      []string{EventTextureUploaded},
+		MaxAttempts: 4,
+	})
+	ctx := context.Background()
+	ids, payloads := []string{}, []string{}
+	for i, endpoint := range []string{"https://a.example.com/hook", "https://b.example.com/hook"} {
+		mock.ExpectExec(quoteSQL("INSERT INTO webhook_deliveries")).
+			WithArgs(capture{&ids}, EventTextureUploaded, endpoint, capture{&payloads}, StatusPending).
+			WillReturnResult(sqlmock.NewResult(int64(10+i), 1))
+		expectEnqueue(mock, int64(20+i), int64(10+i), 4)
+	}
+	if err := d.Emit(ctx, EventTextureUploaded, map[string]string{"hash": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	// events nothing subscribed to are dropped without touching the database
+	if err := d.Emit(ctx, EventTextureDeleted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != ids[1] || payloads[0] != payloads[1] {
+		t.Fatalf("the endpoints got different events: ids %q", ids)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(payloads[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != ids[0] || event.Type != EventTextureUploaded || event.Data.(map[string]any)["hash"] != "abc" {
+		t.Fatalf("stored %s", payloads[0])
+	}
+}
+
+func TestEmitFilters(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		endpoints []string
+		events    []string
+		emitted   bool
+	}{
+		{"subscribed", []string{"https://a.example.com"}, []string{EventPriceUpdated}, true},
+		{"wildcard", []string{"https://a.example.com"}, []string{"*"}, true},
+		{"other event", []string{"https://a.example.com"}, []string{EventTextureUploaded}, false},
+		{"no endpoints", nil, []string{"*"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d, _, mock := newTestDispatcher(t, config.WebhooksSection{Endpoints: test.endpoints, Events: test.events, MaxAttempts: 3})
+			if test.emitted {
+				mock.ExpectExec(quoteSQL("INSERT INTO webhook_deliveries")).
+					WithArgs(sqlmock.AnyArg(), EventPriceUpdated, test.endpoints[0], sqlmock.AnyArg(), StatusPending).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectEnqueue(mock, 2, 1, 3)
+			}
+			if err := d.Emit(context.Background(), EventPriceUpdated, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// endpoint answers the deliveries with the statuses in order and checks
+// every request carries a valid signature.
+type endpoint struct {
+	t        *testing.T
+	secret   string
+	mu       sync.Mutex
+	statuses []int
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	signature := r.Header.Get("X-Webhook-Signature")
+	timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+	if err != nil || Sign(e.secret, timestamp, body) != signature {
+		e.t.Errorf("bad signature %q", signature)
+	}
+	if r.Header.Get("X-Webhook-Id") != "event-id" || r.Header.Get("X-Webhook-Event") != EventTextureDeleted {
+		e.t.Errorf("delivered %s %s", r.Header.Get("X-Webhook-Id"), r.Header.Get("X-Webhook-Event"))
+	}
+	e.mu.Lock()
+	status := e.statuses[0]
+	e.statuses = e.statuses[1:]
+	e.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+// expectAttempt expects the queue to claim the delivery job for its
+// attempt-th run and the dispatcher to load the delivery.
+func expectAttempt(mock sqlmock.Sqlmock, url string, attempt, maxAttempts int) {
+	mock.ExpectQuery(quoteSQL("SELECT id FROM jobs WHERE status = ?")).WithArgs(jobs.StatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(quoteSQL("UPDATE jobs SET status = ?, attempts = attempts + 1")).
+		WithArgs(jobs.StatusRunning, sqlmock.AnyArg(), 7, jobs.StatusQueued).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(quoteSQL("FROM jobs WHERE id = ?")).WithArgs(7).WillReturnRows(jobRows(7, 3, jobs.StatusRunning, attempt, maxAttempts))
+	mock.ExpectQuery(quoteSQL("SELECT event_id, event_type, url, payload FROM webhook_deliveries WHERE id = ?")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id", "event_type", "url", "payload"}).AddRow("event-id", EventTextureDeleted, url, `{"id":"event-id"}`))
+}
+
+// runQueue starts the queue and waits until every expectation was met.
+func runQueue(t *testing.T, queue *jobs.Queue, mock sqlmock.Sqlmock) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := queue.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := mock.ExpectationsWereMet()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeliveryRetriesThenFails(t *testing.T) {
+	server := &endpoint{t: t, secret: "whsec_test", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	_, queue, mock := newTestDispatcher(t, config.WebhooksSection{Endpoints: []string{ts.URL}, Events: []string{"*"}, Secret: "whsec_test", MaxAttempts: 2})
+
+	mock.ExpectExec(quoteSQL("UPDATE jobs SET status = ?, locked_by = NULL")).WillReturnResult(sqlmock.NewResult(0, 0))
+	// the first failure is retried after the queue backoff of 5 seconds
+	expectAttempt(mock, ts.URL, 1, 2)
+	mock.ExpectExec(quoteSQL("UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ? WHERE id = ?")).
+		WithArgs(StatusRetrying, 1, 500, "endpoint answered 500 Internal Server Error", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(quoteSQL("run_after = CURRENT_TIMESTAMP + INTERVAL ? SECOND")).
+		WithArgs(jobs.StatusQueued, "endpoint answered 500 Internal Server Error", 5, 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	// the last attempt marks the delivery and the job failed
+	expectAttempt(mock, ts.URL, 2, 2)
+	mock.ExpectExec(quoteSQL("UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ? WHERE id = ?")).
+		WithArgs(StatusFailed, 2, 502, "endpoint answered 502 Bad Gateway", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(quoteSQL("UPDATE jobs SET status = ?, error = ?, finished_at = CURRENT_TIMESTAMP")).
+		WithArgs(jobs.StatusFailed, "endpoint answered 502 Bad Gateway", 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(quoteSQL("SELECT id FROM jobs WHERE status = ?")).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	runQueue(t, queue, mock)
+}
+
+func TestDeliverySucceeds(t *testing.T) {
+	server := &endpoint{t: t, secret: "whsec_test", statuses: []int{http.StatusNoContent}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	_, queue, mock := newTestDispatcher(t, config.WebhooksSection{Endpoints: []string{ts.URL}, Events: []string{"*"}, Secret: "whsec_test", MaxAttempts: 2})
+
+	mock.ExpectExec(quoteSQL("UPDATE jobs SET status = ?, locked_by = NULL")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAttempt(mock, ts.URL, 1, 2)
+	mock.ExpectExec(quoteSQL("UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = NULL")).
+		WithArgs(StatusDelivered, 1, 204, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(quoteSQL("UPDATE jobs SET status = ?, result = ?")).
+		WithArgs(jobs.StatusDone, `{"status":204}`, 7, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(quoteSQL("SELECT id FROM jobs WHERE status = ?")).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	runQueue(t, queue, mock)
+}